@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ambientsound/pms/api"
+	"github.com/ambientsound/pms/index"
+	"github.com/ambientsound/pms/input/lexer"
+	"github.com/ambientsound/pms/xdg"
+)
+
+// Cache lists and prunes the search indexes cached for each MPD server.
+type Cache struct {
+	newcommand
+	api       api.API
+	action    string
+	olderThan time.Duration
+}
+
+// NewCache returns Cache.
+func NewCache(api api.API) Command {
+	return &Cache{
+		api: api,
+	}
+}
+
+// Parse implements Command.
+func (cmd *Cache) Parse() error {
+
+	tok, lit := cmd.ScanIgnoreWhitespace()
+	cmd.setTabCompleteAction(lit)
+
+	switch tok {
+	case lexer.TokenIdentifier:
+		break
+	default:
+		return fmt.Errorf("Unexpected '%v', expected identifier", lit)
+	}
+
+	switch lit {
+	case "list":
+		break
+	case "prune":
+		if err := cmd.parseOlderThan(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unexpected '%v', expected identifier", lit)
+	}
+
+	cmd.action = lit
+
+	cmd.setTabCompleteEmpty()
+	return cmd.ParseEnd()
+}
+
+// parseOlderThan parses the --older-than flag of the prune action.
+func (cmd *Cache) parseOlderThan() error {
+	flag := cmd.scanWord()
+	if len(flag) == 0 {
+		// Tab completion replaces only the last token, so the flag can
+		// only be completed before any part of it has been typed.
+		cmd.setTabComplete(flag, []string{"--older-than"})
+	} else {
+		cmd.setTabCompleteEmpty()
+	}
+	if flag != "--older-than" {
+		return fmt.Errorf("Unexpected '%v', expected --older-than", flag)
+	}
+
+	cmd.setTabCompleteEmpty()
+	tok, lit := cmd.ScanIgnoreWhitespace()
+	if tok != lexer.TokenIdentifier {
+		return fmt.Errorf("Unexpected '%v', expected age such as 90d", lit)
+	}
+
+	age, err := parseAge(lit)
+	if err != nil {
+		return err
+	}
+	cmd.olderThan = age
+
+	return nil
+}
+
+// scanWord scans tokens up to the next whitespace, and returns them as a
+// single string. The lexer splits flags such as --older-than on each dash.
+func (cmd *Cache) scanWord() string {
+	word := ""
+	tok, lit := cmd.ScanIgnoreWhitespace()
+	for tok != lexer.TokenWhitespace && tok != lexer.TokenEnd {
+		word += lit
+		tok, lit = cmd.Scan()
+	}
+	cmd.Unscan()
+	return word
+}
+
+// Exec implements Command.
+func (cmd *Cache) Exec() error {
+	base := xdg.CacheDirectory()
+
+	switch cmd.action {
+	case "list":
+		cache, err := index.ListCache(base)
+		if err != nil {
+			return err
+		}
+		if len(cache) == 0 {
+			return fmt.Errorf("No cached search indexes in %s.", base)
+		}
+		entries := make([]string, len(cache))
+		for n, c := range cache {
+			entries[n] = fmt.Sprintf("%s:%s (%.1f MB, used %s)",
+				c.Host,
+				c.Port,
+				float64(c.Size)/(1024*1024),
+				c.LastUsed.Format("2006-01-02"),
+			)
+		}
+		cmd.api.Message("Cached search indexes: %s", strings.Join(entries, ", "))
+
+	case "prune":
+		pruned, err := index.PruneCache(base, time.Now().Add(-cmd.olderThan))
+		if err != nil {
+			return err
+		}
+		var size int64
+		for _, c := range pruned {
+			size += c.Size
+		}
+		cmd.api.Message("Pruned %d cached search indexes, freeing %.1f MB.", len(pruned), float64(size)/(1024*1024))
+	}
+
+	return nil
+}
+
+// setTabCompleteAction sets the tab complete list to available actions.
+func (cmd *Cache) setTabCompleteAction(lit string) {
+	list := []string{
+		"list",
+		"prune",
+	}
+	cmd.setTabComplete(lit, list)
+}
+
+// parseAge parses an age such as 90d, 2w or 12h. Units are days, weeks, and
+// anything understood by time.ParseDuration.
+func parseAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+
+	if len(s) > 1 {
+		if unit, ok := units[s[len(s)-1:]]; ok {
+			n, err := strconv.Atoi(s[:len(s)-1])
+			if err == nil && n >= 0 {
+				return time.Duration(n) * unit, nil
+			}
+		}
+	}
+
+	age, err := time.ParseDuration(s)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("Invalid age '%s', expected e.g. 90d, 2w or 12h", s)
+	}
+
+	return age, nil
+}
@@ -0,0 +1,42 @@
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/ambientsound/pms/commands"
+)
+
+var cacheTests = []commands.Test{
+	// Valid forms
+	{`list`, true, nil, nil, []string{}},
+	{`prune --older-than 90d`, true, nil, nil, []string{}},
+	{`prune --older-than 2w`, true, nil, nil, []string{}},
+	{`prune --older-than 36h`, true, nil, nil, []string{}},
+
+	// Invalid forms
+	{`foo`, false, nil, nil, []string{}},
+	{`list list`, false, nil, nil, []string{}},
+	{`prune`, false, nil, nil, []string{
+		"--older-than",
+	}},
+	{`prune -older-than 90d`, false, nil, nil, []string{}},
+	{`prune --older-than`, false, nil, nil, []string{}},
+	{`prune --older-than ninety`, false, nil, nil, []string{}},
+	{`prune --older-than 90d 1`, false, nil, nil, []string{}},
+
+	// Tab completion
+	{``, false, nil, nil, []string{
+		"list",
+		"prune",
+	}},
+	{`p`, false, nil, nil, []string{
+		"prune",
+	}},
+	{`prune `, false, nil, nil, []string{
+		"--older-than",
+	}},
+}
+
+func TestCache(t *testing.T) {
+	commands.TestVerb(t, "cache", cacheTests)
+}
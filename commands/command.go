@@ -20,9 +20,13 @@ import (
 var Verbs = map[string]func(api.API) Command{
 	"add":       NewAdd,
 	"bind":      NewBind,
+	"cache":     NewCache,
 	"copy":      NewYank,
 	"cursor":    NewCursor,
 	"cut":       NewCut,
+	"drilldown": NewDrilldown,
+	"history":   NewHistory,
+	"index":     NewIndex,
 	"inputmode": NewInputMode,
 	"isolate":   NewIsolate,
 	"list":      NewList,
@@ -40,6 +44,7 @@ var Verbs = map[string]func(api.API) Command{
 	"select":    NewSelect,
 	"se":        NewSet,
 	"set":       NewSet,
+	"similar":   NewSimilar,
 	"single":    NewSingle,
 	"sort":      NewSort,
 	"stop":      NewStop,
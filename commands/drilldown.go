@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ambientsound/pms/api"
+	"github.com/ambientsound/pms/input/lexer"
+	"github.com/ambientsound/pms/songlist"
+)
+
+// Drilldown narrows down a list of search results to the songs having one of
+// the tag values counted by the search.
+type Drilldown struct {
+	newcommand
+	api   api.API
+	tag   string
+	index int
+}
+
+// NewDrilldown returns Drilldown.
+func NewDrilldown(api api.API) Command {
+	return &Drilldown{
+		api:   api,
+		index: 1,
+	}
+}
+
+// Parse implements Command.
+func (cmd *Drilldown) Parse() error {
+	tok, lit := cmd.ScanIgnoreWhitespace()
+	cmd.setTabComplete(lit, cmd.facetTags())
+
+	if tok != lexer.TokenIdentifier {
+		return fmt.Errorf("Unexpected '%v', expected tag", lit)
+	}
+	cmd.tag = strings.ToLower(lit)
+
+	tok, lit = cmd.ScanIgnoreWhitespace()
+	cmd.setTabCompleteEmpty()
+
+	switch tok {
+	case lexer.TokenEnd:
+		return nil
+	case lexer.TokenIdentifier:
+		break
+	default:
+		return fmt.Errorf("Unexpected '%v', expected number", lit)
+	}
+
+	n, err := strconv.Atoi(lit)
+	if err != nil || n < 1 {
+		return fmt.Errorf("Unexpected '%v', expected positive number", lit)
+	}
+	cmd.index = n
+
+	return cmd.ParseEnd()
+}
+
+// Exec implements Command.
+func (cmd *Drilldown) Exec() error {
+	library := cmd.api.Library()
+	if library == nil {
+		return fmt.Errorf("Song library is not present.")
+	}
+
+	list, ok := cmd.api.Songlist().(*songlist.SearchResult)
+	if !ok {
+		return fmt.Errorf("Drilldown needs a list of search results.")
+	}
+
+	terms := list.Facet(cmd.tag)
+	if terms == nil {
+		return fmt.Errorf("The values of '%s' are not counted in this search.", cmd.tag)
+	}
+	if cmd.index > len(terms) {
+		return fmt.Errorf("There are only %d values of '%s' in this search.", len(terms), cmd.tag)
+	}
+
+	result, err := library.DrillDown(list, cmd.tag, terms[cmd.index-1].Value)
+	if err != nil {
+		return err
+	}
+
+	if result.Len() == 0 {
+		return fmt.Errorf("No tracks found in this search with that %s.", cmd.tag)
+	}
+
+	panel := cmd.api.Db().Panel()
+	panel.Add(result)
+	panel.Activate(result)
+
+	return nil
+}
+
+// facetTags returns the tags counted by the search results in the current
+// songlist.
+func (cmd *Drilldown) facetTags() []string {
+	list, ok := cmd.api.Songlist().(*songlist.SearchResult)
+	if !ok {
+		return []string{}
+	}
+	tags := make([]string, len(list.Facets()))
+	for n, facet := range list.Facets() {
+		tags[n] = facet.Field
+	}
+	return tags
+}
@@ -0,0 +1,23 @@
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/ambientsound/pms/commands"
+)
+
+var drilldownTests = []commands.Test{
+	// Valid forms
+	{`artist`, true, nil, nil, []string{}},
+	{`album 3`, true, nil, nil, []string{}},
+
+	// Invalid forms
+	{``, false, nil, nil, []string{}},
+	{`artist 0`, false, nil, nil, []string{}},
+	{`artist foo`, false, nil, nil, []string{}},
+	{`artist 1 2`, false, nil, nil, []string{}},
+}
+
+func TestDrilldown(t *testing.T) {
+	commands.TestVerb(t, "drilldown", drilldownTests)
+}
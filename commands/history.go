@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ambientsound/pms/api"
+	"github.com/ambientsound/pms/input/lexer"
+)
+
+// historyListSize is the number of recent searches shown by History.
+const historyListSize = 10
+
+// History shows recent searches, or repeats one of them.
+type History struct {
+	newcommand
+	api   api.API
+	index int
+}
+
+// NewHistory returns History.
+func NewHistory(api api.API) Command {
+	return &History{
+		api: api,
+	}
+}
+
+// Parse implements Command.
+func (cmd *History) Parse() error {
+	tok, lit := cmd.ScanIgnoreWhitespace()
+
+	switch tok {
+	case lexer.TokenEnd:
+		return nil
+	case lexer.TokenIdentifier:
+		break
+	default:
+		return fmt.Errorf("Unexpected '%v', expected number", lit)
+	}
+
+	n, err := strconv.Atoi(lit)
+	if err != nil || n < 1 {
+		return fmt.Errorf("Unexpected '%v', expected positive number", lit)
+	}
+	cmd.index = n
+
+	return cmd.ParseEnd()
+}
+
+// Exec implements Command.
+func (cmd *History) Exec() error {
+	library := cmd.api.Library()
+	if library == nil {
+		return fmt.Errorf("Song library is not present.")
+	}
+
+	history := library.SearchHistory()
+	if len(history) == 0 {
+		return fmt.Errorf("Search history is empty.")
+	}
+
+	// Most recent searches first.
+	recent := make([]string, len(history))
+	for n := range history {
+		recent[n] = history[len(history)-1-n]
+	}
+
+	if cmd.index == 0 {
+		if len(recent) > historyListSize {
+			recent = recent[:historyListSize]
+		}
+		entries := make([]string, len(recent))
+		for n, q := range recent {
+			entries[n] = fmt.Sprintf("%d: %s", n+1, q)
+		}
+		cmd.api.Message("Recent searches: %s", strings.Join(entries, ", "))
+		return nil
+	}
+
+	if cmd.index > len(recent) {
+		return fmt.Errorf("Search history has only %d entries.", len(recent))
+	}
+
+	q := recent[cmd.index-1]
+	result, err := library.Search(q)
+	if err != nil {
+		return err
+	}
+
+	if result.Len() == 0 {
+		return fmt.Errorf("No results found for '%s'.", q)
+	}
+
+	if err = library.AddSearchHistory(q); err != nil {
+		return err
+	}
+
+	panel := cmd.api.Db().Panel()
+	panel.Add(result)
+	panel.Activate(result)
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/ambientsound/pms/commands"
+)
+
+var historyTests = []commands.Test{
+	// Valid forms
+	{``, true, nil, nil, []string{}},
+	{`1`, true, nil, nil, []string{}},
+	{`12`, true, nil, nil, []string{}},
+
+	// Invalid forms
+	{`0`, false, nil, nil, []string{}},
+	{`-1`, false, nil, nil, []string{}},
+	{`foo`, false, nil, nil, []string{}},
+	{`1 2`, false, nil, nil, []string{}},
+}
+
+func TestHistory(t *testing.T) {
+	commands.TestVerb(t, "history", historyTests)
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/ambientsound/pms/api"
+	"github.com/ambientsound/pms/input/lexer"
+)
+
+// Index manages the search index of the song library.
+type Index struct {
+	newcommand
+	api    api.API
+	action string
+}
+
+// NewIndex returns Index.
+func NewIndex(api api.API) Command {
+	return &Index{
+		api: api,
+	}
+}
+
+// Parse implements Command.
+func (cmd *Index) Parse() error {
+
+	tok, lit := cmd.ScanIgnoreWhitespace()
+	cmd.setTabCompleteAction(lit)
+
+	switch tok {
+	case lexer.TokenIdentifier:
+		break
+	default:
+		return fmt.Errorf("Unexpected '%v', expected identifier", lit)
+	}
+
+	switch lit {
+	case "abort", "purge", "rebuild", "stats":
+		break
+	default:
+		return fmt.Errorf("Unexpected '%v', expected identifier", lit)
+	}
+
+	cmd.action = lit
+
+	cmd.setTabCompleteEmpty()
+	return cmd.ParseEnd()
+}
+
+// Exec implements Command.
+func (cmd *Index) Exec() error {
+	library := cmd.api.Library()
+	if library == nil {
+		return fmt.Errorf("Song library is not present.")
+	}
+
+	switch cmd.action {
+	case "abort":
+		if !library.AbortReIndex() {
+			return fmt.Errorf("The search index is not being rebuilt.")
+		}
+
+	case "purge":
+		if err := library.PurgeIndex(); err != nil {
+			return err
+		}
+		cmd.api.Message("Search index has been deleted, and is being rebuilt.")
+
+	case "rebuild":
+		if !library.HasIndex() {
+			return fmt.Errorf("Search index is not open.")
+		}
+		library.ReIndex()
+
+	case "stats":
+		stats, err := library.IndexStats()
+		if err != nil {
+			return err
+		}
+		cmd.api.Message("%d songs indexed, %.1f MB on disk, schema version %d, library version %d.",
+			stats.Documents,
+			float64(stats.Size)/(1024*1024),
+			stats.Schema,
+			stats.Version,
+		)
+	}
+
+	return nil
+}
+
+// setTabCompleteAction sets the tab complete list to available actions.
+func (cmd *Index) setTabCompleteAction(lit string) {
+	list := []string{
+		"abort",
+		"purge",
+		"rebuild",
+		"stats",
+	}
+	cmd.setTabComplete(lit, list)
+}
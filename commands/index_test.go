@@ -0,0 +1,37 @@
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/ambientsound/pms/commands"
+)
+
+var indexTests = []commands.Test{
+	// Valid forms
+	{`abort`, true, nil, nil, []string{}},
+	{`purge`, true, nil, nil, []string{}},
+	{`rebuild`, true, nil, nil, []string{}},
+	{`stats`, true, nil, nil, []string{}},
+
+	// Invalid forms
+	{`foo`, false, nil, nil, []string{}},
+	{`abort abort`, false, nil, nil, []string{}},
+
+	// Tab completion
+	{``, false, nil, nil, []string{
+		"abort",
+		"purge",
+		"rebuild",
+		"stats",
+	}},
+	{`re`, false, nil, nil, []string{
+		"rebuild",
+	}},
+	{`a`, false, nil, nil, []string{
+		"abort",
+	}},
+}
+
+func TestIndex(t *testing.T) {
+	commands.TestVerb(t, "index", indexTests)
+}
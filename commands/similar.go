@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/ambientsound/pms/api"
+)
+
+// Similar searches for songs that resemble the song under the cursor.
+type Similar struct {
+	newcommand
+	api api.API
+}
+
+// NewSimilar returns Similar.
+func NewSimilar(api api.API) Command {
+	return &Similar{
+		api: api,
+	}
+}
+
+// Parse implements Command.
+func (cmd *Similar) Parse() error {
+	return cmd.ParseEnd()
+}
+
+// Exec implements Command.
+func (cmd *Similar) Exec() error {
+	library := cmd.api.Library()
+	if library == nil {
+		return fmt.Errorf("Song library is not present.")
+	}
+
+	panel := cmd.api.Db().Panel()
+	song := cmd.api.Songlist().CursorSong()
+	if song == nil {
+		return fmt.Errorf("Similar needs a track under the cursor.")
+	}
+
+	result, err := library.Similar(song)
+	if err != nil {
+		return err
+	}
+
+	if result.Len() == 0 {
+		return fmt.Errorf("No similar tracks found.")
+	}
+
+	panel.Add(result)
+	panel.Activate(result)
+
+	return nil
+}
@@ -0,0 +1,19 @@
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/ambientsound/pms/commands"
+)
+
+var similarTests = []commands.Test{
+	// Valid forms
+	{``, true, nil, nil, []string{}},
+
+	// Invalid forms
+	{`foo`, false, nil, nil, []string{}},
+}
+
+func TestSimilar(t *testing.T) {
+	commands.TestVerb(t, "similar", similarTests)
+}
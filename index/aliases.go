@@ -0,0 +1,124 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ALIAS_PREFIX marks a query alias in a search query.
+const ALIAS_PREFIX = "@"
+
+// SetAlias defines a named query alias. When a natural language search
+// contains the token "@name", the token is replaced by the aliased query
+// before searching. Aliases are not expanded recursively. Setting an alias to
+// an empty query removes it.
+//
+// Aliases are saved alongside the index state, and are remembered when the
+// index is reopened.
+func (i *Index) SetAlias(name, q string) error {
+	name = strings.TrimPrefix(name, ALIAS_PREFIX)
+	if len(name) == 0 || strings.ContainsAny(name, " \t\n") {
+		return fmt.Errorf("Invalid alias name '%s'", name)
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.aliases == nil {
+		i.aliases = make(map[string]string)
+	}
+	q = normalizeQuery(q)
+	if len(q) == 0 {
+		delete(i.aliases, name)
+	} else {
+		i.aliases[name] = q
+	}
+
+	return i.writeAliases()
+}
+
+// Aliases returns a copy of all defined query aliases.
+func (i *Index) Aliases() map[string]string {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	aliases := make(map[string]string, len(i.aliases))
+	for name, q := range i.aliases {
+		aliases[name] = q
+	}
+
+	return aliases
+}
+
+// expandAliases replaces alias tokens in a query with their aliased queries.
+// Unknown aliases are left as they are.
+func (i *Index) expandAliases(q string) string {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	if len(i.aliases) == 0 {
+		return q
+	}
+
+	tokens := strings.Fields(q)
+	for n, token := range tokens {
+		if !strings.HasPrefix(token, ALIAS_PREFIX) {
+			continue
+		}
+		if expanded, ok := i.aliases[token[len(ALIAS_PREFIX):]]; ok {
+			tokens[n] = expanded
+		}
+	}
+
+	return strings.Join(tokens, " ")
+}
+
+// writeAliases writes all query aliases to the alias file, one per line. The
+// caller must hold the write lock.
+func (i *Index) writeAliases() error {
+	if i.memOnly {
+		return nil
+	}
+
+	names := make([]string, 0, len(i.aliases))
+	for name := range i.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s %s\n", name, i.aliases[name])
+	}
+
+	return writeFileAtomic(i.aliasPath, buf.Bytes())
+}
+
+// readAliases reads query aliases from the alias file. A missing file means
+// that no aliases are defined.
+func (i *Index) readAliases() (map[string]string, error) {
+	aliases := make(map[string]string)
+
+	file, err := os.Open(i.aliasPath)
+	if os.IsNotExist(err) {
+		return aliases, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		aliases[parts[0]] = parts[1]
+	}
+
+	return aliases, scanner.Err()
+}
@@ -0,0 +1,144 @@
+package index
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/analysis/lang/de"
+	"github.com/blevesearch/bleve/analysis/lang/es"
+	"github.com/blevesearch/bleve/analysis/lang/fr"
+	"github.com/blevesearch/bleve/analysis/lang/it"
+	"github.com/blevesearch/bleve/analysis/lang/pt"
+	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/analysis/token/porter"
+	"github.com/blevesearch/bleve/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/mapping"
+)
+
+// Analyzers for the text of song tags.
+//
+// ANALYZER_SONG splits tags into words at whitespace, and matches any prefix
+// of a word, so that "beat" finds "Beatles". No words are ignored, so bands
+// such as "The The" or "Yes" can be found. ANALYZER_STANDARD splits tags
+// into words using Unicode text segmentation, and ignores common English
+// words such as "the"; words must match entirely. ANALYZER_KEYWORD treats
+// each tag as a single word, so that only entire tag values match. All
+// analyzers ignore case, and all except ANALYZER_STANDARD ignore diacritics,
+// so that "motorhead" finds "Motörhead".
+//
+// The language analyzers split tags into words using Unicode text
+// segmentation, and reduce each word to its stem in the given language, so
+// that e.g. "loving" finds "Love". Words must match entirely, and no words
+// are ignored.
+const (
+	ANALYZER_SONG       = "song"
+	ANALYZER_STANDARD   = "standard"
+	ANALYZER_KEYWORD    = "keyword"
+	ANALYZER_ENGLISH    = "english"
+	ANALYZER_FRENCH     = "french"
+	ANALYZER_GERMAN     = "german"
+	ANALYZER_ITALIAN    = "italian"
+	ANALYZER_PORTUGUESE = "portuguese"
+	ANALYZER_SPANISH    = "spanish"
+)
+
+// languageStemmers maps the language analyzer settings to the Bleve token
+// filter stemming words in that language.
+var languageStemmers = map[string]string{
+	ANALYZER_ENGLISH:    porter.Name,
+	ANALYZER_FRENCH:     fr.LightStemmerName,
+	ANALYZER_GERMAN:     de.LightStemmerName,
+	ANALYZER_ITALIAN:    it.LightStemmerName,
+	ANALYZER_PORTUGUESE: pt.LightStemmerName,
+	ANALYZER_SPANISH:    es.LightStemmerName,
+}
+
+// Analyzer selects the analyzer used for the text of song tags in natural
+// language and fielded searches. The default is ANALYZER_SONG. Exact match
+// fields, used by e.g. SameAlbum and SearchPrefix, are not affected. The
+// setting has no effect if a mapping file is used; see MappingFile.
+//
+// The analyzer is recorded along with the schema version of the index. If an
+// existing index was created with another analyzer, it is recreated, and the
+// library must be reindexed.
+func Analyzer(name string) Option {
+	return func(i *Index) {
+		i.mapping.analyzer = name
+	}
+}
+
+// ValidAnalyzer returns an error if name is not one of the analyzer
+// settings, so that a setting can be checked before an open index is closed
+// in favour of one using the new analyzer.
+func ValidAnalyzer(name string) error {
+	_, err := analyzerName(name)
+	return err
+}
+
+// analyzerName returns the name of the Bleve analyzer implementing one of
+// the analyzer settings.
+func analyzerName(name string) (string, error) {
+	switch name {
+	case ANALYZER_SONG:
+		return "songAnalyzer", nil
+	case ANALYZER_STANDARD:
+		return standard.Name, nil
+	case ANALYZER_KEYWORD:
+		return EXACT_ANALYZER, nil
+	}
+	if _, ok := languageStemmers[name]; ok {
+		return "songAnalyzer_" + name, nil
+	}
+	return "", fmt.Errorf("Unknown analyzer '%s'", name)
+}
+
+// addLanguageAnalyzer adds the Bleve analyzer implementing a language
+// analyzer setting to the index mapping. Other settings are ignored. Words
+// are stemmed before diacritics are removed, as the stemmers depend on them.
+func addLanguageAnalyzer(m *mapping.IndexMappingImpl, name string) error {
+	stemmer, ok := languageStemmers[name]
+	if !ok {
+		return nil
+	}
+
+	analyzer, err := analyzerName(name)
+	if err != nil {
+		return err
+	}
+
+	return m.AddCustomAnalyzer(analyzer,
+		map[string]interface{}{
+			"type":         custom.Name,
+			"char_filters": []interface{}{},
+			"tokenizer":    unicode.Name,
+			"token_filters": []interface{}{
+				lowercase.Name,
+				stemmer,
+				`unicodeStripper`,
+				`asciiFolder`,
+			},
+		})
+}
+
+// readSchemaAnalyzer reads the analyzer setting from the schema file. It is
+// stored on the line following the schema version. Indexes created before
+// the setting was recorded use ANALYZER_SONG.
+func (i *Index) readSchemaAnalyzer() string {
+	file, err := os.Open(i.schemaPath)
+	if err != nil {
+		return ANALYZER_SONG
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 0; scanner.Scan(); line++ {
+		if line == 1 && len(scanner.Text()) > 0 {
+			return scanner.Text()
+		}
+	}
+
+	return ANALYZER_SONG
+}
@@ -0,0 +1,77 @@
+package index
+
+import (
+	"context"
+	"time"
+
+	"github.com/ambientsound/pms/song"
+)
+
+// IndexProgress reports the progress of a full index started with
+// IndexFullAsync.
+type IndexProgress struct {
+	Done     int           // number of songs written to the index so far
+	Total    int           // number of songs to index
+	Elapsed  time.Duration // time spent indexing so far
+	ETA      time.Duration // estimated time until indexing completes
+	Finished bool          // true in the last event, after indexing has ended
+	Err      error         // reason indexing failed, in the last event only
+}
+
+// IndexFullAsync starts indexing the entire song list in the background, and
+// returns a channel of progress events. An event is sent each time a batch of
+// songs has been written to the index; if the receiver falls behind, all but
+// the latest event are dropped. When indexing ends, a last event with
+// Finished set is sent, and the channel is closed.
+//
+// Indexing is aborted when the context is cancelled, in which case the last
+// event holds the context error. As with IndexFullContext, an aborted index
+// leaves the old index data untouched.
+func (i *Index) IndexFullAsync(ctx context.Context, songs []*song.Song) <-chan IndexProgress {
+	events := make(chan IndexProgress, 1)
+	started := time.Now()
+
+	// send replaces any event not yet received with a newer one.
+	send := func(event IndexProgress) {
+		for {
+			select {
+			case events <- event:
+				return
+			default:
+			}
+			select {
+			case <-events:
+			default:
+			}
+		}
+	}
+
+	go func() {
+		defer close(events)
+
+		indexed := 0
+		progress := func(done, total int) {
+			indexed = done
+			elapsed := time.Since(started)
+			event := IndexProgress{Done: done, Total: total, Elapsed: elapsed}
+			if done > 0 {
+				event.ETA = time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+			}
+			send(event)
+		}
+
+		err := i.indexFullContext(ctx, songs, progress)
+		if err == nil {
+			indexed = len(songs)
+		}
+		send(IndexProgress{
+			Done:     indexed,
+			Total:    len(songs),
+			Elapsed:  time.Since(started),
+			Finished: true,
+			Err:      err,
+		})
+	}()
+
+	return events
+}
@@ -0,0 +1,34 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// writeFileAtomic replaces the contents of a file, such that the file holds
+// either its old or its new contents even if the process is interrupted.
+// The data is written to a temporary file in the same directory, synced to
+// disk, and renamed into place.
+func writeFileAtomic(filename string, data []byte) error {
+	file, err := ioutil.TempFile(path.Dir(filename), "."+path.Base(filename))
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
@@ -0,0 +1,133 @@
+package index
+
+import (
+	"math"
+	"sort"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// DEFAULT_FIELD_BOOSTS are the field boosts used unless SetFieldBoosts is
+// called. They rank matches in the primary tags above matches in secondary
+// tags such as the genre or file name.
+var DEFAULT_FIELD_BOOSTS = map[string]float64{
+	"artist":      2.0,
+	"albumartist": 2.0,
+	"title":       2.0,
+	"album":       1.5,
+}
+
+// SetFieldBoosts configures the relative weight of song tags in natural
+// language searches, e.g. {"title": 2.0}. Songs matching the query in a
+// boosted tag are ranked higher than songs matching it only elsewhere.
+// Tags not in the map have a weight of 1, and an empty map disables
+// boosting. Boosts only ever raise scores, so weights of 1 or less have no
+// effect.
+//
+// Boosts are applied at query time, so changes take effect on the next
+// search without reindexing.
+func (i *Index) SetFieldBoosts(boosts map[string]float64) error {
+	fields := make(map[string]float64, len(boosts))
+	for tag, boost := range boosts {
+		name, err := i.indexedField(tag)
+		if err != nil {
+			return err
+		}
+		fields[name] = boost
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.fieldBoosts = fields
+
+	return nil
+}
+
+// setDefaultFieldBoosts applies DEFAULT_FIELD_BOOSTS, leaving out tags which
+// are not indexed.
+func (i *Index) setDefaultFieldBoosts() {
+	boosts := make(map[string]float64, len(DEFAULT_FIELD_BOOSTS))
+	for tag, boost := range DEFAULT_FIELD_BOOSTS {
+		if _, err := i.indexedField(tag); err == nil {
+			boosts[tag] = boost
+		}
+	}
+	i.SetFieldBoosts(boosts)
+}
+
+// boostFields wraps a natural language query so that songs matching the
+// query string in any of the boosted fields get a higher score.
+func (i *Index) boostFields(q string, base query.Query) query.Query {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	fields := make([]string, 0, len(i.fieldBoosts))
+	for field := range i.fieldBoosts {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	// Each boosted field is added in a separate boolean query, rather than
+	// together in a disjunction, as Bleve scales the score of a disjunction
+	// by the fraction of matching clauses.
+	for _, field := range fields {
+		boost := i.fieldBoosts[field]
+		if boost <= 1 {
+			continue
+		}
+		match := bleve.NewMatchQuery(q)
+		match.SetField(field)
+
+		boolean := bleve.NewBooleanQuery()
+		boolean.AddMust(base)
+		boolean.AddShould(&additiveQuery{Query: match, scale: boost - 1})
+		base = boolean
+	}
+
+	return base
+}
+
+// additiveQuery wraps a query so that its score is added to the score of
+// the surrounding query, without changing the scores of the other clauses.
+//
+// Bleve normalizes the scores of all clauses in a query by their combined
+// weight. Adding boosted clauses would therefore lower the score of the
+// original query, and push results below the score threshold. The wrapped
+// query is instead normalized on its own, as if it were run by itself, and
+// its score multiplied by scale. It reports no weight to the surrounding
+// query.
+type additiveQuery struct {
+	query.Query
+	scale float64
+}
+
+// Searcher implements query.Query.
+func (q *additiveQuery) Searcher(i index.IndexReader, m mapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	searcher, err := q.Query.Searcher(i, m, options)
+	if err != nil {
+		return nil, err
+	}
+	if weight := searcher.Weight(); weight > 0 {
+		searcher.SetQueryNorm(q.scale / math.Sqrt(weight))
+	}
+	return &additiveSearcher{searcher}, nil
+}
+
+// additiveSearcher is the searcher of additiveQuery.
+type additiveSearcher struct {
+	search.Searcher
+}
+
+// Weight implements search.Searcher.
+func (s *additiveSearcher) Weight() float64 {
+	return 0
+}
+
+// SetQueryNorm implements search.Searcher, ignoring the query norm of the
+// surrounding query.
+func (s *additiveSearcher) SetQueryNorm(float64) {
+}
@@ -0,0 +1,203 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// Clause is a single condition of a query built with QueryBuilder. Clauses
+// are created with Term, Field, Phrase, Exact and Group.
+type Clause interface {
+	query(i *Index) (query.Query, error)
+}
+
+// termClause matches text in any song tag.
+type termClause struct {
+	text string
+}
+
+// Term returns a clause matching songs where any tag contains the words of
+// text. No query syntax is interpreted.
+func Term(text string) Clause {
+	return termClause{text: text}
+}
+
+func (c termClause) query(i *Index) (query.Query, error) {
+	return bleve.NewMatchQuery(normalizeQuery(c.text)), nil
+}
+
+// fieldClause matches a value against a single song tag.
+type fieldClause struct {
+	tag   string
+	value string
+	match func(field, value string) query.Query
+}
+
+// Field returns a clause matching songs where the given tag, e.g. "artist",
+// contains the words of value, like SearchField.
+func Field(tag, value string) Clause {
+	return fieldClause{
+		tag:   tag,
+		value: value,
+		match: func(field, value string) query.Query {
+			q := bleve.NewMatchQuery(normalizeQuery(value))
+			q.SetField(field)
+			return q
+		},
+	}
+}
+
+// Phrase returns a clause matching songs where the words of phrase appear in
+// the same order in the given tag. If tag is empty, any tag may match, like
+// SearchPhrase.
+func Phrase(tag, phrase string) Clause {
+	return fieldClause{
+		tag:   tag,
+		value: phrase,
+		match: func(field, value string) query.Query {
+			q := bleve.NewMatchPhraseQuery(normalizeQuery(value))
+			q.SetField(field)
+			return q
+		},
+	}
+}
+
+// Exact returns a clause matching songs where the given tag is exactly equal
+// to value, ignoring case and diacritics, like FilterExact.
+func Exact(tag, value string) Clause {
+	return fieldClause{
+		tag:   tag,
+		value: value,
+		match: func(field, value string) query.Query {
+			return exactQuery(field, value)
+		},
+	}
+}
+
+func (c fieldClause) query(i *Index) (query.Query, error) {
+	field := ""
+	if len(c.tag) > 0 {
+		name, err := i.indexedField(c.tag)
+		if err != nil {
+			return nil, err
+		}
+		field = name
+	}
+	return c.match(field, c.value), nil
+}
+
+// groupClause nests a query within another.
+type groupClause struct {
+	builder *QueryBuilder
+}
+
+// Group returns a clause matching songs that match the query of b. Groups
+// allow combining clauses, e.g. requiring that at least one of several sets
+// of clauses matches.
+func Group(b *QueryBuilder) Clause {
+	return groupClause{builder: b}
+}
+
+func (c groupClause) query(i *Index) (query.Query, error) {
+	return c.builder.Query(i)
+}
+
+// QueryBuilder builds boolean queries from clauses, as an alternative to
+// query strings and raw Bleve requests. Songs must match all clauses added
+// with Must, and none of the clauses added with Not. Clauses added with
+// Should improve the score of matching songs; if there are no Must clauses,
+// at least one of them must match.
+//
+// A query with only Not clauses matches all other songs.
+type QueryBuilder struct {
+	must   []Clause
+	should []Clause
+	not    []Clause
+}
+
+// NewQueryBuilder returns an empty QueryBuilder.
+func NewQueryBuilder() *QueryBuilder {
+	return &QueryBuilder{
+		must:   make([]Clause, 0),
+		should: make([]Clause, 0),
+		not:    make([]Clause, 0),
+	}
+}
+
+// Must adds clauses that songs are required to match.
+func (b *QueryBuilder) Must(clauses ...Clause) *QueryBuilder {
+	b.must = append(b.must, clauses...)
+	return b
+}
+
+// Should adds clauses that songs are preferred to match.
+func (b *QueryBuilder) Should(clauses ...Clause) *QueryBuilder {
+	b.should = append(b.should, clauses...)
+	return b
+}
+
+// Not adds clauses that songs must not match.
+func (b *QueryBuilder) Not(clauses ...Clause) *QueryBuilder {
+	b.not = append(b.not, clauses...)
+	return b
+}
+
+// Empty returns true if no clauses have been added.
+func (b *QueryBuilder) Empty() bool {
+	return len(b.must) == 0 && len(b.should) == 0 && len(b.not) == 0
+}
+
+// Query returns the Bleve query for the clauses, suitable for use with
+// Index.Query. An error is returned if the query is empty, or if a clause
+// refers to a tag that is not indexed.
+func (b *QueryBuilder) Query(i *Index) (query.Query, error) {
+	if b.Empty() {
+		return nil, fmt.Errorf("Query has no clauses")
+	}
+
+	must, err := clauseQueries(i, b.must)
+	if err != nil {
+		return nil, err
+	}
+	should, err := clauseQueries(i, b.should)
+	if err != nil {
+		return nil, err
+	}
+	not, err := clauseQueries(i, b.not)
+	if err != nil {
+		return nil, err
+	}
+
+	return query.NewBooleanQuery(must, should, not), nil
+}
+
+// clauseQueries returns the Bleve queries of a list of clauses.
+func clauseQueries(i *Index, clauses []Clause) ([]query.Query, error) {
+	queries := make([]query.Query, len(clauses))
+	for n, c := range clauses {
+		q, err := c.query(i)
+		if err != nil {
+			return nil, err
+		}
+		queries[n] = q
+	}
+	return queries, nil
+}
+
+// SearchQuery returns the positions of at most size songs matching the query
+// built by b, ordered by relevance. Since built queries usually act as
+// filters, results are not discarded by the score threshold.
+func (i *Index) SearchQuery(b *QueryBuilder, size int) ([]int, error) {
+	q, err := b.Query(i)
+	if err != nil {
+		return nil, err
+	}
+
+	request := bleve.NewSearchRequest(q)
+	request.Size = size
+
+	r, _, err := i.query(request, 0, nil)
+	return r, err
+}
@@ -0,0 +1,142 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/ambientsound/pms/console"
+)
+
+// CachedIndex describes the search index of a single MPD server, as stored
+// within a cache directory by PathWithBase.
+type CachedIndex struct {
+	Host     string
+	Port     string
+	Path     string    // directory holding the index and its state
+	Size     int64     // size of all files in the directory, in bytes
+	LastUsed time.Time // time the index was last opened or written to
+	Locked   bool      // the index is held open by a running process
+}
+
+// ListCache returns all server indexes found within the base directory,
+// sorted by the time they were last used, most recently used first.
+// Directories that do not hold an index state file are ignored.
+func ListCache(base string) ([]CachedIndex, error) {
+	indexes := make([]CachedIndex, 0)
+
+	hosts, err := ioutil.ReadDir(base)
+	if os.IsNotExist(err) {
+		return indexes, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, host := range hosts {
+		if !host.IsDir() {
+			continue
+		}
+		ports, err := ioutil.ReadDir(path.Join(base, host.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, port := range ports {
+			if !port.IsDir() {
+				continue
+			}
+			dir := PathWithBase(base, host.Name(), port.Name())
+			if _, err := os.Stat(path.Join(dir, "state")); err != nil {
+				continue
+			}
+			c, err := cachedIndex(dir)
+			if err != nil {
+				return nil, err
+			}
+			c.Host = host.Name()
+			c.Port = port.Name()
+			indexes = append(indexes, c)
+		}
+	}
+
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return indexes[a].LastUsed.After(indexes[b].LastUsed)
+	})
+
+	return indexes, nil
+}
+
+// PruneCache deletes the server indexes within the base directory that have
+// not been used since the given time. Indexes held open by a running process
+// are never deleted. The deleted indexes are returned.
+func PruneCache(base string, before time.Time) ([]CachedIndex, error) {
+	indexes, err := ListCache(base)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := make([]CachedIndex, 0)
+	for _, c := range indexes {
+		if c.Locked || !c.LastUsed.Before(before) {
+			continue
+		}
+		if err = os.RemoveAll(c.Path); err != nil {
+			return pruned, err
+		}
+		// Remove the host directory too, if this was its last index.
+		os.Remove(path.Dir(c.Path))
+		console.Log("Pruned search index for %s:%s from %s", c.Host, c.Port, c.Path)
+		pruned = append(pruned, c)
+	}
+
+	return pruned, nil
+}
+
+// cachedIndex collects the size, last use and lock status of the index
+// stored in dir.
+func cachedIndex(dir string) (CachedIndex, error) {
+	c := CachedIndex{
+		Path: dir,
+	}
+
+	i := &Index{
+		indexPath: path.Join(dir, "index"),
+	}
+	size, err := i.diskSize()
+	if err != nil && !os.IsNotExist(err) {
+		return c, err
+	}
+	c.Size = size
+
+	// The index directory is touched when the index is opened, and files
+	// written to it update the modification time of their own.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return c, err
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			c.Size += file.Size()
+		}
+		if file.ModTime().After(c.LastUsed) {
+			c.LastUsed = file.ModTime()
+		}
+	}
+	if info, err := os.Stat(dir); err == nil && info.ModTime().After(c.LastUsed) {
+		c.LastUsed = info.ModTime()
+	}
+
+	c.Locked, err = isLocked(storePath(i.indexPath))
+
+	return c, err
+}
+
+// touch records that the index directory has been used by updating its
+// modification time. The time is used by ListCache and PruneCache.
+func (i *Index) touch() {
+	now := time.Now()
+	if err := os.Chtimes(i.path, now, now); err != nil {
+		console.Log("Unable to update modification time of %s: %s", i.path, err)
+	}
+}
@@ -0,0 +1,64 @@
+package index
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// MIN_FREE_SPACE is the default amount of free disk space, in bytes, that
+// CheckPath requires in the cache directory.
+const MIN_FREE_SPACE int64 = 64 * 1024 * 1024
+
+// ErrPathNotWritable is returned by CheckPath when files cannot be created in
+// the cache directory.
+var ErrPathNotWritable = fmt.Errorf("Directory is not writable")
+
+// ErrInsufficientSpace is returned by CheckPath when the file system holding
+// the cache directory has less free space than required.
+var ErrInsufficientSpace = fmt.Errorf("Not enough free disk space")
+
+// PathError describes a problem with the cache directory found by CheckPath.
+// Err is one of ErrPathNotWritable or ErrInsufficientSpace, or an error from
+// the file system.
+type PathError struct {
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("Search index directory %s: %s", e.Path, e.Err)
+}
+
+// CheckPath verifies that a search index can be created in basePath. The
+// directory is created if it does not exist. See CheckPathSpace.
+func CheckPath(basePath string) error {
+	return CheckPathSpace(basePath, MIN_FREE_SPACE)
+}
+
+// CheckPathSpace works like CheckPath, but requires minFree bytes of free
+// disk space instead of MIN_FREE_SPACE. Free space is not checked on
+// platforms where it cannot be determined.
+func CheckPathSpace(basePath string, minFree int64) error {
+	err := createDirectory(basePath)
+	if err != nil {
+		return &PathError{Path: basePath, Err: err}
+	}
+
+	file, err := ioutil.TempFile(basePath, ".check")
+	if err != nil {
+		return &PathError{Path: basePath, Err: ErrPathNotWritable}
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	free, err := freeSpace(basePath)
+	if err != nil {
+		return &PathError{Path: basePath, Err: err}
+	}
+	if free >= 0 && free < minFree {
+		return &PathError{Path: basePath, Err: ErrInsufficientSpace}
+	}
+
+	return nil
+}
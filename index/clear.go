@@ -0,0 +1,88 @@
+package index
+
+import (
+	"github.com/ambientsound/pms/console"
+)
+
+// Clear deletes all songs from the index, and resets the index version to
+// zero, so that the index is rebuilt the next time it is synchronized with
+// the MPD library. Unlike creating a new index with New, the index stays open
+// and keeps its directory. The number of deleted songs is returned.
+//
+// Songs are deleted in batches of BatchSize. The write lock is held
+// throughout, so searches wait until the index has been cleared.
+func (i *Index) Clear() (int, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.closed {
+		return 0, ErrIndexClosed
+	}
+	if i.indexing {
+		return 0, ErrIndexingInProgress
+	}
+
+	ids, err := i.docIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	// Bleve may still be iterating a batch after Batch returns, so each
+	// chunk of deletions gets a new batch instead of resetting the last one.
+	b := i.bleveIndex.NewBatch()
+	for n, id := range ids {
+		b.Delete(id)
+		if (n+1)%i.batchSize != 0 && n+1 < len(ids) {
+			continue
+		}
+		if err = i.bleveIndex.Batch(b); err != nil {
+			return n + 1 - b.Size(), err
+		}
+		b = i.bleveIndex.NewBatch()
+	}
+
+	if err = i.setVersion(0); err != nil {
+		return len(ids), err
+	}
+
+	console.Log("Cleared %d songs from search index.", len(ids))
+
+	return len(ids), nil
+}
+
+// docIDs returns the IDs of all documents in the index. The caller must hold
+// the index lock.
+func (i *Index) docIDs() ([]string, error) {
+	idx, _, err := i.bleveIndex.Advanced()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := idx.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	all, err := reader.DocIDReaderAll()
+	if err != nil {
+		return nil, err
+	}
+	defer all.Close()
+
+	ids := make([]string, 0)
+	for {
+		iid, err := all.Next()
+		if err != nil {
+			return nil, err
+		}
+		if iid == nil {
+			return ids, nil
+		}
+		id, err := reader.ExternalID(iid)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+}
@@ -0,0 +1,113 @@
+package index
+
+import (
+	"sort"
+
+	"github.com/blevesearch/bleve"
+	bleveindex "github.com/blevesearch/bleve/index"
+)
+
+// CompleteField returns up to n distinct values of a song tag which start
+// with the given prefix, in alphabetical order. Both the prefix and the
+// returned values are normalized the same way as exact matches, i.e. they
+// are lowercased and stripped of diacritics.
+func (i *Index) CompleteField(field, prefix string, n int) ([]string, error) {
+	name, err := i.indexedField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	term, err := i.exactTerm(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var dict bleveindex.FieldDict
+	if len(term) == 0 {
+		dict, err = i.bleveIndex.FieldDict(exactFieldName(name))
+	} else {
+		dict, err = i.bleveIndex.FieldDictPrefix(exactFieldName(name), []byte(term))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer dict.Close()
+
+	r := make([]string, 0, n)
+	for len(r) < n {
+		entry, err := dict.Next()
+		if err != nil {
+			return nil, err
+		} else if entry == nil {
+			break
+		}
+		if len(entry.Term) == 0 {
+			continue
+		}
+		r = append(r, entry.Term)
+	}
+
+	return r, nil
+}
+
+// Suggest returns up to limit distinct values of a song tag which start with
+// the given prefix, e.g. all artist names starting with "rad", for type-ahead
+// completion. Unlike CompleteField, the values are returned as they are
+// tagged, sorted alphabetically. Values of tags that are not stored in the
+// index are returned in their normalized form; see StoredFields.
+func (i *Index) Suggest(field, prefix string, limit int) ([]string, error) {
+	terms, err := i.CompleteField(field, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := i.indexedField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(terms))
+	r := make([]string, 0, len(terms))
+	for _, term := range terms {
+		value, err := i.storedValue(name, term)
+		if err != nil {
+			return nil, err
+		}
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		r = append(r, value)
+	}
+
+	sort.Strings(r)
+
+	return r, nil
+}
+
+// storedValue returns the stored value of a document field, from any song
+// where the field is indexed as the given exact match term. If the field is
+// not stored, the term itself is returned.
+func (i *Index) storedValue(field, term string) (string, error) {
+	q := bleve.NewTermQuery(term)
+	q.SetField(exactFieldName(field))
+	request := bleve.NewSearchRequest(q)
+	request.Size = 1
+	request.Fields = []string{field}
+
+	_, sr, err := i.query(request, 0, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sr.Hits) > 0 {
+		if value, ok := sr.Hits[0].Fields[field].(string); ok && len(value) > 0 {
+			return value, nil
+		}
+	}
+
+	return term, nil
+}
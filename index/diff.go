@@ -0,0 +1,72 @@
+package index
+
+import (
+	"sort"
+
+	index_song "github.com/ambientsound/pms/index/song"
+	"github.com/ambientsound/pms/song"
+	"github.com/blevesearch/bleve"
+)
+
+// Diff compares a song list against the indexed songs, and returns the
+// positions that need to be updated for the index to match the list:
+//
+// added contains positions in the list which are not indexed yet.
+//
+// removed contains indexed positions which are beyond the end of the list.
+//
+// changed contains positions where the indexed song has a different file
+// name, or different indexed contents, than the song in the list.
+//
+// All positions are returned in ascending order.
+func (i *Index) Diff(songs []*song.Song) (added, removed, changed []int, err error) {
+	count, err := i.DocCount()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	request := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	request.Size = int(count)
+	request.Fields = []string{"File", "Hash"}
+
+	_, sr, err := i.queryAll(request)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	added = make([]int, 0)
+	removed = make([]int, 0)
+	changed = make([]int, 0)
+	indexed := make(map[int]bool, len(sr.Hits))
+
+	for _, hit := range sr.Hits {
+		pos, err := hitPosition(hit)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		indexed[pos] = true
+
+		if pos >= len(songs) {
+			removed = append(removed, pos)
+			continue
+		}
+
+		doc := index_song.New(songs[pos])
+		file, _ := hit.Fields["File"].(string)
+		hash, _ := hit.Fields["Hash"].(string)
+		if file != doc.File || hash != doc.Hash {
+			changed = append(changed, pos)
+		}
+	}
+
+	for pos := range songs {
+		if !indexed[pos] {
+			added = append(added, pos)
+		}
+	}
+
+	sort.Ints(removed)
+	sort.Ints(changed)
+
+	return added, removed, changed, nil
+}
@@ -0,0 +1,209 @@
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/ambientsound/pms/console"
+	index_song "github.com/ambientsound/pms/index/song"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
+)
+
+// EXPORT_FORMAT_VERSION is the version of the archive format written by
+// Export. It must be incremented whenever the format changes.
+const EXPORT_FORMAT_VERSION int = 1
+
+// exportHeader is the first record of an archive written by Export.
+type exportHeader struct {
+	Format  int // archive format; see EXPORT_FORMAT_VERSION
+	Schema  int // index schema version; see INDEX_SCHEMA_VERSION
+	Version int // MPD library version the index was built from
+}
+
+// exportRecord holds a single song document in an archive.
+type exportRecord struct {
+	Pos  int
+	Song index_song.Song
+}
+
+// Export writes the songs in the index, along with the MPD library version,
+// to an archive which can be restored with Import, e.g. on another machine.
+// The archive is a stream of JSON records. Only tags stored in the index are
+// exported; see StoredFields.
+func (i *Index) Export(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+
+	header := exportHeader{
+		Format:  EXPORT_FORMAT_VERSION,
+		Schema:  INDEX_SCHEMA_VERSION,
+		Version: i.Version(),
+	}
+	if err := encoder.Encode(header); err != nil {
+		return err
+	}
+
+	request := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	request.Size = SEARCH_PAGE_SIZE
+	request.SortBy([]string{"_id"})
+
+	for {
+		r, sr, err := i.queryAll(request)
+		if err != nil {
+			return err
+		}
+
+		for _, pos := range r {
+			i.mutex.RLock()
+			doc, err := i.bleveIndex.Document(strconv.Itoa(pos))
+			i.mutex.RUnlock()
+			if err != nil {
+				return err
+			} else if doc == nil {
+				continue
+			}
+
+			record := exportRecord{
+				Pos:  pos,
+				Song: songFromDocument(doc),
+			}
+			if err = encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+
+		request.From += len(sr.Hits)
+		if len(sr.Hits) < SEARCH_PAGE_SIZE {
+			return nil
+		}
+	}
+}
+
+// Import replaces the contents of the index with the songs in an archive
+// written by Export, and sets the library version to the one recorded in the
+// archive. Archives written with another archive format or index schema
+// version are rejected; rebuild the index from the MPD library instead.
+//
+// As with IndexFull, the old index data is left untouched if the import
+// fails.
+func (i *Index) Import(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+
+	header := exportHeader{}
+	if err := decoder.Decode(&header); err != nil {
+		return fmt.Errorf("while reading search index archive: %s", err)
+	}
+	if header.Format != EXPORT_FORMAT_VERSION {
+		return fmt.Errorf("Search index archive format %d is not supported; expected %d", header.Format, EXPORT_FORMAT_VERSION)
+	}
+	if header.Schema != INDEX_SCHEMA_VERSION {
+		return fmt.Errorf("Search index archive has schema version %d, but this version of PMS uses %d; rebuild the index instead", header.Schema, INDEX_SCHEMA_VERSION)
+	}
+
+	if err := i.startIndexing(); err != nil {
+		return err
+	}
+	defer i.stopIndexing()
+
+	next, err := i.createNext()
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	batchSize := i.BatchSize()
+	b := next.NewBatch()
+
+	for {
+		record := exportRecord{}
+		err = decoder.Decode(&record)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			i.discardNext(next)
+			return fmt.Errorf("while reading search index archive: %s", err)
+		}
+
+		if err = b.Index(strconv.Itoa(record.Pos), record.Song); err != nil {
+			i.discardNext(next)
+			return err
+		}
+		count++
+		if b.Size() < batchSize {
+			continue
+		}
+		if err = next.Batch(b); err != nil {
+			i.discardNext(next)
+			return err
+		}
+		b.Reset()
+	}
+
+	if b.Size() > 0 {
+		if err = next.Batch(b); err != nil {
+			i.discardNext(next)
+			return err
+		}
+	}
+
+	if err = i.swap(next); err != nil {
+		return err
+	}
+
+	console.Log("Imported %d songs into search index.", count)
+
+	i.checkSize()
+
+	return i.SetVersion(header.Version)
+}
+
+// songFromDocument reconstructs a song document from the fields stored in
+// the index. Fields which are not stored are left empty.
+func songFromDocument(doc *document.Document) index_song.Song {
+	s := index_song.Song{}
+	v := reflect.ValueOf(&s).Elem()
+
+	for _, f := range doc.Fields {
+		field := v.FieldByName(f.Name())
+		if !field.IsValid() {
+			continue
+		}
+
+		switch f := f.(type) {
+		case *document.BooleanField:
+			b, err := f.Boolean()
+			if err != nil {
+				break
+			}
+			if field.Kind() == reflect.Ptr {
+				field.Set(reflect.ValueOf(&b))
+			} else {
+				field.SetBool(b)
+			}
+		case *document.NumericField:
+			n, err := f.Number()
+			if err == nil && field.Kind() == reflect.Ptr {
+				field.Set(reflect.ValueOf(&n))
+			}
+		case *document.DateTimeField:
+			t, err := f.DateTime()
+			if err == nil {
+				field.SetString(t.UTC().Format(time.RFC3339))
+			}
+		default:
+			value := reflect.ValueOf(string(f.Value()))
+			switch field.Kind() {
+			case reflect.String:
+				field.SetString(value.String())
+			case reflect.Slice:
+				field.Set(reflect.Append(field, value))
+			}
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,150 @@
+package index
+
+import (
+	"strconv"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+)
+
+// FacetTerm is a distinct value of a song tag, along with the number of songs
+// having that value.
+type FacetTerm struct {
+	Value string
+	Count int
+}
+
+// Facet returns the size most common values of a song tag, e.g. "genre",
+// along with the number of songs having each value, ordered by decreasing
+// count. Tag values are counted in their entirety, ignoring case and
+// diacritics, and are returned as they are tagged, as for Suggest. Songs
+// without a value for the tag are not counted.
+func (i *Index) Facet(field string, size int) ([]FacetTerm, error) {
+	name, err := i.indexedField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	facet := "values"
+	request := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	request.Size = 0
+	// Songs without the tag are counted under an empty term, which is left
+	// out of the results; fetch one extra term in its place.
+	request.AddFacet(facet, bleve.NewFacetRequest(exactFieldName(name), size+1))
+
+	i.mutex.RLock()
+	if i.closed {
+		i.mutex.RUnlock()
+		return nil, ErrIndexClosed
+	}
+	sr, err := i.bleveIndex.Search(request)
+	i.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return i.facetTerms(name, sr.Facets[facet], size)
+}
+
+// FacetResult holds the distinct values of a song tag among the results of a
+// search, as returned by Facets.
+type FacetResult struct {
+	Field string      // song tag, as given to Facets
+	Terms []FacetTerm // all distinct values, ordered by decreasing count
+}
+
+// Facets runs a natural language query, and counts the distinct values of
+// each of the given song tags among the matching songs, e.g. so that a search
+// can be summarized as "23 albums, 4 artists". One result is returned per
+// field, in the order given. Tag values are counted as for Facet.
+//
+// Songs are counted if they are returned by Search for the same query, using
+// the default result size. To count the songs of a search while running it,
+// use the Facets option of SearchWith instead.
+func (i *Index) Facets(q string, fields []string) ([]FacetResult, error) {
+	r, err := i.SearchWith(q, SearchOptions{Facets: fields})
+	return r.Facets, err
+}
+
+// countFacets counts the distinct values of each of the given song tags among
+// the songs at the given positions. Names holds the document field name of
+// each tag; see indexedFields.
+func (i *Index) countFacets(positions []int, fields, names []string) ([]FacetResult, error) {
+	r := make([]FacetResult, len(fields))
+	if len(positions) == 0 {
+		for n := range fields {
+			r[n] = FacetResult{Field: fields[n], Terms: make([]FacetTerm, 0)}
+		}
+		return r, nil
+	}
+
+	ids := make([]string, len(positions))
+	for n, pos := range positions {
+		ids[n] = strconv.Itoa(pos)
+	}
+
+	// Every song may have a distinct value, so ask for as many terms as
+	// there are songs.
+	request := bleve.NewSearchRequest(bleve.NewDocIDQuery(ids))
+	request.Size = 0
+	for n, name := range names {
+		request.AddFacet(fields[n], bleve.NewFacetRequest(exactFieldName(name), len(ids)+1))
+	}
+
+	i.mutex.RLock()
+	if i.closed {
+		i.mutex.RUnlock()
+		return nil, ErrIndexClosed
+	}
+	sr, err := i.bleveIndex.Search(request)
+	i.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	for n, name := range names {
+		terms, err := i.facetTerms(name, sr.Facets[fields[n]], len(ids))
+		if err != nil {
+			return nil, err
+		}
+		r[n] = FacetResult{Field: fields[n], Terms: terms}
+	}
+
+	return r, nil
+}
+
+// indexedFields returns the document field names of the given song tags. An
+// error is returned if any of the tags are not indexed.
+func (i *Index) indexedFields(fields []string) ([]string, error) {
+	names := make([]string, len(fields))
+	for n, field := range fields {
+		name, err := i.indexedField(field)
+		if err != nil {
+			return nil, err
+		}
+		names[n] = name
+	}
+	return names, nil
+}
+
+// facetTerms converts at most size terms of a Bleve facet result into facet
+// terms, skipping the empty term counting songs without the tag.
+func (i *Index) facetTerms(name string, result *search.FacetResult, size int) ([]FacetTerm, error) {
+	r := make([]FacetTerm, 0)
+	if result == nil || result.Terms == nil {
+		return r, nil
+	}
+
+	for _, term := range result.Terms {
+		if len(term.Term) == 0 || len(r) >= size {
+			continue
+		}
+		value, err := i.storedValue(name, term.Term)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, FacetTerm{Value: value, Count: term.Count})
+	}
+
+	return r, nil
+}
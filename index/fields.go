@@ -0,0 +1,180 @@
+package index
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	index_song "github.com/ambientsound/pms/index/song"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// fieldName returns the name of the index document field corresponding to a
+// song tag, e.g. "albumartist" yields "Albumartist" and "has_lyrics" yields
+// "HasLyrics". An error is returned if there is no such field in the index.
+func fieldName(tag string) (string, error) {
+	parts := strings.Split(strings.ToLower(tag), "_")
+	for i := range parts {
+		parts[i] = strings.Title(parts[i])
+	}
+	name := strings.Join(parts, "")
+	if _, ok := reflect.TypeOf(index_song.Song{}).FieldByName(name); !ok {
+		return "", fmt.Errorf("Tag '%s' is not indexed", tag)
+	}
+	return name, nil
+}
+
+// indexedField works like fieldName, but also returns an error if the field
+// exists, but was left out of the index; see IndexedTags.
+func (i *Index) indexedField(tag string) (string, error) {
+	name, err := fieldName(tag)
+	if err != nil {
+		return "", err
+	}
+
+	i.mutex.RLock()
+	m, ok := i.bleveIndex.Mapping().(*mapping.IndexMappingImpl)
+	i.mutex.RUnlock()
+	if !ok || m.DefaultMapping == nil {
+		return name, nil
+	}
+
+	property, ok := m.DefaultMapping.Properties[name]
+	if ok && len(property.Fields) > 0 && !property.Fields[0].Index {
+		return "", fmt.Errorf("Tag '%s' is not indexed", tag)
+	}
+
+	return name, nil
+}
+
+// numericField returns the name of the numeric index field corresponding to
+// a song tag, e.g. "track" yields "TrackNumber". An error is returned if the
+// tag has no numeric field, or if the index was created without it.
+func (i *Index) numericField(tag string) (string, error) {
+	name, err := i.indexedField(tag + "_number")
+	if err != nil {
+		return "", fmt.Errorf("Tag '%s' is not indexed as a number", tag)
+	}
+
+	if !i.hasFieldType(name, "number") {
+		return "", fmt.Errorf("Search index does not support numeric searches; try rebuilding it")
+	}
+
+	return name, nil
+}
+
+// dateField returns the name of the date index field corresponding to a
+// song tag, e.g. "modified". An error is returned if the tag is not a date,
+// or if the index was created without the field.
+func (i *Index) dateField(tag string) (string, error) {
+	name, err := i.indexedField(tag)
+	if err != nil {
+		return "", err
+	}
+
+	field, _ := reflect.TypeOf(index_song.Song{}).FieldByName(name)
+	if !isDateField(field) {
+		return "", fmt.Errorf("Tag '%s' is not indexed as a date", tag)
+	}
+
+	if !i.hasFieldType(name, "datetime") {
+		return "", fmt.Errorf("Search index does not support date searches; try rebuilding it")
+	}
+
+	return name, nil
+}
+
+// hasFieldType returns true if the given document field is mapped with the
+// given Bleve field type, e.g. "number". Custom mappings are assumed to have
+// the correct field types.
+func (i *Index) hasFieldType(name, fieldType string) bool {
+	i.mutex.RLock()
+	m, ok := i.bleveIndex.Mapping().(*mapping.IndexMappingImpl)
+	i.mutex.RUnlock()
+	if !ok || m.DefaultMapping == nil {
+		return true
+	}
+
+	property, ok := m.DefaultMapping.Properties[name]
+	return ok && len(property.Fields) > 0 && property.Fields[0].Type == fieldType
+}
+
+// Indexed returns true if the given song tag is indexed, and can be searched.
+func (i *Index) Indexed(tag string) bool {
+	_, err := i.indexedField(tag)
+	return err == nil
+}
+
+// exactFieldName returns the name of the index field used for exact matches
+// against the given document field.
+func exactFieldName(field string) string {
+	return field + "Exact"
+}
+
+// exactQuery returns a query matching documents where the given document
+// field is exactly equal to value, ignoring case and diacritics.
+func exactQuery(field, value string) *query.MatchQuery {
+	q := bleve.NewMatchQuery(value)
+	q.SetField(exactFieldName(field))
+	q.Analyzer = EXACT_ANALYZER
+	return q
+}
+
+// exactTerm returns the term that a value is indexed as in exact match fields.
+func (i *Index) exactTerm(value string) (string, error) {
+	analyzer := i.bleveIndex.Mapping().AnalyzerNamed(EXACT_ANALYZER)
+	if analyzer == nil {
+		return "", fmt.Errorf("Search index does not support exact matches; try rebuilding it")
+	}
+	tokens := analyzer.Analyze([]byte(value))
+	if len(tokens) == 0 {
+		return "", nil
+	}
+	return string(tokens[0].Term), nil
+}
+
+// documentFields returns the fields of the song document type.
+func documentFields() []reflect.StructField {
+	t := reflect.TypeOf(index_song.Song{})
+	fields := make([]reflect.StructField, t.NumField())
+	for i := range fields {
+		fields[i] = t.Field(i)
+	}
+	return fields
+}
+
+// isNumericField returns true if a document field contains an optional number.
+func isNumericField(field reflect.StructField) bool {
+	return field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Float64
+}
+
+// isBoolField returns true if a document field contains a boolean, which may
+// be optional.
+func isBoolField(field reflect.StructField) bool {
+	t := field.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Bool
+}
+
+// isDateField returns true if a document field contains a timestamp.
+func isDateField(field reflect.StructField) bool {
+	return field.Type == reflect.TypeOf(index_song.Timestamp(""))
+}
+
+// isTextField returns true if a document field contains one or more strings.
+func isTextField(field reflect.StructField) bool {
+	if isDateField(field) {
+		return false
+	}
+	switch field.Type.Kind() {
+	case reflect.String:
+		return true
+	case reflect.Slice:
+		return field.Type.Elem().Kind() == reflect.String
+	}
+	return false
+}
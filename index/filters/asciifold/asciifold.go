@@ -0,0 +1,57 @@
+// Package asciifold provides a Bleve token filter which replaces letters that
+// have no decomposed unicode form with their closest ASCII equivalents.
+package asciifold
+
+import (
+	"strings"
+
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/registry"
+)
+
+const Name = "fold_ascii"
+
+// replacer maps letters which are not composed of a base letter and a
+// diacritic mark, and thus are left alone by unicode decomposition, to ASCII.
+var replacer = strings.NewReplacer(
+	"æ", "ae", "Æ", "AE",
+	"ø", "o", "Ø", "O",
+	"œ", "oe", "Œ", "OE",
+	"ß", "ss", "ẞ", "SS",
+	"đ", "d", "Đ", "D",
+	"ð", "d", "Ð", "D",
+	"ħ", "h", "Ħ", "H",
+	"ı", "i",
+	"ł", "l", "Ł", "L",
+	"þ", "th", "Þ", "TH",
+)
+
+// ASCIIFoldingFilter is a Bleve token filter which folds letters such as
+// "ø" and "æ" into ASCII, e.g. "Røyksopp" is indexed as "Royksopp". Letters
+// with diacritic marks, such as "ö", should be handled by decomposing them
+// first; see the unicodestrip package.
+type ASCIIFoldingFilter struct {
+}
+
+// New returns a new instance of ASCIIFoldingFilter.
+func New() (*ASCIIFoldingFilter, error) {
+	return &ASCIIFoldingFilter{}, nil
+}
+
+// Constructor provides a constructor for Bleve.
+func Constructor(config map[string]interface{}, cache *registry.Cache) (analysis.TokenFilter, error) {
+	return New()
+}
+
+// Filter folds letters into ASCII in a token stream.
+func (s *ASCIIFoldingFilter) Filter(input analysis.TokenStream) analysis.TokenStream {
+	for _, token := range input {
+		token.Term = []byte(replacer.Replace(string(token.Term)))
+	}
+	return input
+}
+
+// init registers this plugin with Bleve.
+func init() {
+	registry.RegisterTokenFilter(Name, Constructor)
+}
@@ -0,0 +1,82 @@
+package index
+
+import (
+	"strings"
+
+	"github.com/ambientsound/pms/utils"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// MAX_FUZZINESS is the largest edit distance supported by Bleve fuzzy queries.
+const MAX_FUZZINESS int = 2
+
+// SearchFuzzy runs a typo tolerant search, matching each word of the query
+// against words in the song tags that are within the given edit distance,
+// e.g. "beetles" yields songs by the Beatles. The fuzziness is clamped to
+// the range 0 to MAX_FUZZINESS. All words of the query must match.
+//
+// Fuzzy matches score lower than exact ones, so the score threshold is
+// applied relative to the best match: songs scoring less than the threshold
+// times the score of the best matching song are discarded. With a fuzziness
+// of zero, the threshold is applied as for other searches.
+func (i *Index) SearchFuzzy(q string, fuzziness int, size int) ([]int, error) {
+	request := bleve.NewSearchRequest(fuzzyQuery(q, "", fuzziness))
+	request.Size = size
+
+	if fuzziness <= 0 {
+		r, _, err := i.Query(request)
+		return r, err
+	}
+
+	scored, sr, err := i.queryScored(request, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	scored = fuzzyThreshold(scored, sr.MaxScore, i.ScoreThreshold())
+
+	r := make([]int, len(scored))
+	for n := range scored {
+		r[n] = scored[n].Pos
+	}
+
+	return r, nil
+}
+
+// fuzzyThreshold discards fuzzy matches scoring less than threshold times
+// maxScore, the score of the best match. A threshold of zero or less keeps
+// all matches.
+func fuzzyThreshold(scored []ScoredPosition, maxScore, threshold float64) []ScoredPosition {
+	if threshold <= 0 {
+		return scored
+	}
+
+	r := make([]ScoredPosition, 0, len(scored))
+	for _, hit := range scored {
+		if hit.Score >= threshold*maxScore {
+			r = append(r, hit)
+		}
+	}
+
+	return r
+}
+
+// fuzzyQuery returns a query matching songs where each word of the query is
+// within the given edit distance of a word in the document field. If field
+// is empty, all tags are searched.
+func fuzzyQuery(q, field string, fuzziness int) *query.ConjunctionQuery {
+	fuzziness = utils.Max(0, utils.Min(fuzziness, MAX_FUZZINESS))
+
+	conjunction := bleve.NewConjunctionQuery()
+	for _, term := range strings.Fields(q) {
+		// The exact analyzer keeps each word intact, instead of splitting it
+		// into n-grams, which would each be matched fuzzily.
+		match := bleve.NewMatchQuery(term)
+		match.Analyzer = EXACT_ANALYZER
+		match.SetFuzziness(fuzziness)
+		match.SetField(field)
+		conjunction.AddQuery(match)
+	}
+
+	return conjunction
+}
@@ -0,0 +1,95 @@
+package index
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+)
+
+// HighlightedHit is a search result, containing the position of a matching
+// song, along with fragments of the song tags where the query matched.
+type HighlightedHit struct {
+	Pos int
+
+	// Fragments maps tag names, e.g. "title", to the matching fragments of
+	// that tag. Matched terms are marked with <mark> and </mark>.
+	Fragments map[string][]string
+}
+
+// Span is a range of bytes in a song tag, such as a term matched by a search.
+type Span struct {
+	Start int
+	End   int
+}
+
+// hitSpans returns the ranges of each song tag where a search hit matched,
+// taken from the term locations of the hit. Matches in exact match fields are
+// attributed to their tag. The ranges of each tag are sorted, and overlapping
+// ranges are merged.
+func hitSpans(hit *search.DocumentMatch) map[string][]Span {
+	spans := make(map[string][]Span)
+
+	for field, terms := range hit.Locations {
+		tag := strings.ToLower(strings.TrimSuffix(field, exactFieldName("")))
+		for _, locations := range terms {
+			for _, location := range locations {
+				spans[tag] = append(spans[tag], Span{
+					Start: int(location.Start),
+					End:   int(location.End),
+				})
+			}
+		}
+	}
+
+	for tag, s := range spans {
+		sort.Slice(s, func(a, b int) bool {
+			return s[a].Start < s[b].Start
+		})
+		merged := s[:1]
+		for _, span := range s[1:] {
+			last := &merged[len(merged)-1]
+			if span.Start > last.End {
+				merged = append(merged, span)
+			} else if span.End > last.End {
+				last.End = span.End
+			}
+		}
+		spans[tag] = merged
+	}
+
+	return spans
+}
+
+// SearchHighlighted works like Search, but also returns the fragments of the
+// song tags that matched the query, for showing why a song matched. Only
+// tags stored in the index are highlighted; see StoredFields.
+func (i *Index) SearchHighlighted(q string, size int) ([]HighlightedHit, error) {
+	request := i.searchRequest(normalizeQuery(q), size)
+	request.Highlight = bleve.NewHighlight()
+
+	scored, sr, err := i.queryScored(request, i.ScoreThreshold(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	fragments := make(map[string]map[string][]string, len(sr.Hits))
+	for _, hit := range sr.Hits {
+		fragments[hit.ID] = hit.Fragments
+	}
+
+	r := make([]HighlightedHit, len(scored))
+	for n, hit := range scored {
+		r[n] = HighlightedHit{
+			Pos:       hit.Pos,
+			Fragments: make(map[string][]string),
+		}
+		for field, f := range fragments[strconv.Itoa(hit.Pos)] {
+			r[n].Fragments[strings.ToLower(field)] = f
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,98 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+)
+
+// SEARCH_HISTORY_SIZE is the default number of search queries remembered by
+// AddHistory.
+const SEARCH_HISTORY_SIZE int = 100
+
+// HistorySize configures the number of search queries remembered by
+// AddHistory. Older queries are forgotten.
+func HistorySize(size int) Option {
+	return func(i *Index) {
+		i.historySize = size
+	}
+}
+
+// AddHistory appends a search query to the search history. Empty queries, and
+// queries that are equal to the last one, are ignored. Only the last
+// HistorySize queries are kept.
+//
+// The history is saved alongside the index state, and is remembered when the
+// index is reopened.
+func (i *Index) AddHistory(q string) error {
+	q = normalizeQuery(q)
+	if len(q) == 0 {
+		return nil
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if n := len(i.history); n > 0 && i.history[n-1] == q {
+		return nil
+	}
+
+	i.history = append(i.history, q)
+	if i.historySize > 0 && len(i.history) > i.historySize {
+		i.history = i.history[len(i.history)-i.historySize:]
+	}
+
+	return i.writeHistory()
+}
+
+// History returns a copy of the search history, oldest query first.
+func (i *Index) History() []string {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	history := make([]string, len(i.history))
+	copy(history, i.history)
+
+	return history
+}
+
+// writeHistory writes the search history to the history file, one query per
+// line. The caller must hold the write lock.
+func (i *Index) writeHistory() error {
+	if i.memOnly {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, q := range i.history {
+		buf.WriteString(q + "\n")
+	}
+
+	return writeFileAtomic(i.historyPath, buf.Bytes())
+}
+
+// readHistory reads the search history from the history file. A missing file
+// means that the history is empty.
+func (i *Index) readHistory() ([]string, error) {
+	history := make([]string, 0)
+
+	file, err := os.Open(i.historyPath)
+	if os.IsNotExist(err) {
+		return history, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if q := normalizeQuery(scanner.Text()); len(q) > 0 {
+			history = append(history, q)
+		}
+	}
+	if i.historySize > 0 && len(history) > i.historySize {
+		history = history[len(history)-i.historySize:]
+	}
+
+	return history, scanner.Err()
+}
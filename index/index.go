@@ -1,61 +1,282 @@
+// Package index provides a Bleve full-text search index over the MPD song
+// library.
+//
+// Indexes can be encrypted at rest with a passphrase; see Passphrase. New
+// index directories are created readable by their owner only; the
+// permissions of existing directories are left as they are.
 package index
 
 import (
 	"bufio"
+	"context"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/ambientsound/pms/console"
-	index_song "github.com/ambientsound/pms/index/song"
 	"github.com/ambientsound/pms/song"
+	"github.com/ambientsound/pms/utils"
 	"github.com/ambientsound/pms/xdg"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
 
 	"fmt"
 	"strconv"
+	"strings"
 )
 
+// INDEX_BATCH_SIZE is the default number of songs committed to the index at once.
 const INDEX_BATCH_SIZE int = 1000
 
+// SEARCH_SCORE_THRESHOLD is the default minimum score of search results.
 const SEARCH_SCORE_THRESHOLD float64 = 0.5
 
+// INVALID_VERSION is an index version which never matches an MPD library version.
+const INVALID_VERSION int = -1
+
+// ErrNotFound is returned when a requested document or field is not present
+// in the index.
+var ErrNotFound = fmt.Errorf("Not found in search index")
+
+// ErrIndexingInProgress is returned when trying to start indexing, or to
+// update songs in place, while the index is already being rebuilt.
+var ErrIndexingInProgress = fmt.Errorf("Search indexing is already in progress")
+
+// ErrIndexLocked is returned when opening an index which is in use by another
+// process, typically another instance of PMS.
+var ErrIndexLocked = fmt.Errorf("Search index is in use by another process")
+
+// ErrIndexClosed is returned when using an index after it has been closed.
+var ErrIndexClosed = fmt.Errorf("Search index is closed")
+
 type Index struct {
-	bleveIndex bleve.Index
-	path       string
-	indexPath  string
-	statePath  string
-	version    int
+	bleveIndex  bleve.Index
+	mutex       sync.RWMutex
+	path        string
+	indexPath   string
+	statePath   string
+	schemaPath  string
+	aliasPath   string
+	indexedPath string
+	historyPath string
+	version     int
+	mapping     mappingOptions
+
+	// batchSize is the number of songs committed to the index at once.
+	batchSize int
+
+	// scoreThreshold is the minimum score of natural language search results.
+	scoreThreshold float64
+
+	recentlyPlayed []string
+
+	// aliases maps query alias names to the queries they expand to.
+	aliases map[string]string
+
+	// fieldBoosts maps field names to their boost in natural language searches.
+	fieldBoosts map[string]float64
+
+	// resume is non-nil while indexing is paused, and is closed on Resume.
+	resume chan struct{}
+
+	// indexing is true while a full index is in progress.
+	indexing bool
+
+	// closed is true after Close has been called.
+	closed bool
+
+	// memOnly is true if the index is kept in memory only; see NewInMemory.
+	memOnly bool
+
+	onBatchCommitted func(done, total int)
+
+	// lastQueries holds the last query made by each caller of SearchRemember.
+	lastQueries map[string]rememberedQuery
+
+	maxSize     int64
+	sizeWarning func(size int64)
+
+	// server is the address of the MPD server, see Server.
+	server string
+
+	// workers is the number of batches indexed concurrently.
+	workers int
+
+	// lastIndexed is the time of the last full rebuild; see LastIndexed.
+	lastIndexed time.Time
+
+	// metrics receives performance measurements; see SetMetrics.
+	metrics Metrics
+
+	// maxResults is the largest number of results returned by a search.
+	maxResults int
+
+	// history holds recent search queries, oldest first; see AddHistory.
+	history     []string
+	historySize int
+}
+
+// Option configures an Index. Options are passed to New, and are applied
+// before the index is opened or created.
+type Option func(*Index)
+
+// StoredFields configures which song tags have their values stored in the
+// index, making them available through FieldValue. By default, all tags are
+// stored. Storing fewer tags reduces the size of the index on disk.
+//
+// The setting only takes effect when the index is created; an existing index
+// keeps the configuration it was created with.
+func StoredFields(tags ...string) Option {
+	return func(i *Index) {
+		i.mapping.stored = tags
+	}
 }
 
 func createDirectory(dir string) error {
-	dirMode := os.ModeDir | 0755
+	dirMode := os.ModeDir | 0700
 	return os.MkdirAll(dir, dirMode)
 }
 
+// IndexedTags configures which song tags are included in the index. By
+// default, all tags are indexed. Indexing fewer tags makes the index smaller
+// and faster to build, at the cost of not being able to search the other
+// tags. Fielded searches against tags that are not indexed return an error.
+//
+// The setting only takes effect when the index is created; an existing index
+// keeps the configuration it was created with.
+func IndexedTags(tags ...string) Option {
+	return func(i *Index) {
+		i.mapping.indexed = tags
+	}
+}
+
+// MappingFile configures a JSON file containing a Bleve index mapping, which
+// is used instead of the built-in mapping when creating the index. If the
+// file does not exist, the built-in mapping is used. Fielded searches such as
+// SameAlbum depend on fields defined by the built-in mapping, and may not
+// work with a custom mapping. The file name and the internal fields used by
+// Diff are always mapped as in the built-in mapping.
+//
+// The mapping is only read when the index is created; an existing index
+// keeps the mapping it was created with.
+func MappingFile(path string) Option {
+	return func(i *Index) {
+		i.mapping.file = path
+	}
+}
+
+// CustomizeMapping configures a function which modifies the Bleve index
+// mapping before the index is created, e.g. to change the analyzer of a
+// field. The function receives the built-in mapping, or the mapping read from
+// the file configured with MappingFile. Only fields of the song document are
+// indexed, so the mapping of other fields has no effect.
+//
+// The function is only called when the index is created; an existing index
+// keeps the mapping it was created with.
+func CustomizeMapping(fn func(m *mapping.IndexMappingImpl) error) Option {
+	return func(i *Index) {
+		i.mapping.customize = fn
+	}
+}
+
+// NewWithMappingFile works like New, but creates the index using the Bleve
+// index mapping found in mappingPath. See MappingFile.
+func NewWithMappingFile(basePath, mappingPath string, options ...Option) (*Index, error) {
+	return New(basePath, append(options, MappingFile(mappingPath))...)
+}
+
+// newIndex returns an Index without a Bleve index, configured with the given
+// options.
+func newIndex(options []Option) (*Index, error) {
+	var err error
+
+	i := &Index{
+		batchSize:      INDEX_BATCH_SIZE,
+		scoreThreshold: SEARCH_SCORE_THRESHOLD,
+		workers:        1,
+		history:        make([]string, 0),
+		historySize:    SEARCH_HISTORY_SIZE,
+	}
+	i.mapping.scoring = SCORING_TFIDF
+	i.mapping.analyzer = ANALYZER_SONG
+	for _, option := range options {
+		option(i)
+	}
+
+	err = validateScoring(i.mapping.scoring)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidAnalyzer(i.mapping.analyzer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Options refer to song tags; translate them into document field names.
+	for _, tags := range [][]string{i.mapping.stored, i.mapping.indexed} {
+		for n, tag := range tags {
+			tags[n], err = fieldName(tag)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return i, nil
+}
+
 // New opens a Bleve index and returns Index. In case an index is not found at
 // the given path, a new one is created. In case of an error, nil is returned,
 // and the error object set accordingly.
-func New(basePath string) (*Index, error) {
+func New(basePath string, options ...Option) (*Index, error) {
 	var err error
 
 	timer := time.Now()
 
+	i, err := newIndex(options)
+	if err != nil {
+		return nil, err
+	}
+
 	err = createDirectory(basePath)
 	if err != nil {
 		return nil, fmt.Errorf("while creating %s: %s", basePath, err)
 	}
 
-	i := &Index{}
 	i.path = basePath
 	i.indexPath = path.Join(i.path, "index")
 	i.statePath = path.Join(i.path, "state")
+	i.schemaPath = path.Join(i.path, "schema")
+	i.aliasPath = path.Join(i.path, "aliases")
+	i.indexedPath = path.Join(i.path, "indexed")
+	i.historyPath = path.Join(i.path, "history")
+
+	i.aliases, err = i.readAliases()
+	if err != nil {
+		console.Log("index alias file is broken: %s", err)
+	}
+
+	i.history, err = i.readHistory()
+	if err != nil {
+		console.Log("search history file is broken: %s", err)
+		i.history = make([]string, 0)
+	}
+
+	err = i.migrateSchema()
+	if err == ErrIndexLocked || err == ErrPassphraseRequired {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("while removing outdated index at %s: %s", i.indexPath, err)
+	}
 
 	// Try to stat the Bleve index path. If it does not exist, create it.
 	if _, err := os.Stat(i.indexPath); err != nil {
 		if os.IsNotExist(err) {
-			i.bleveIndex, err = create(i.indexPath)
+			i.bleveIndex, err = create(i.indexPath, i.mapping)
 			if err != nil {
 				return nil, fmt.Errorf("while creating index at %s: %s", i.indexPath, err)
 			}
@@ -66,6 +287,14 @@ func New(basePath string) (*Index, error) {
 				return nil, fmt.Errorf("while zeroing out library version at %s: %s", i.statePath, err)
 			}
 
+			err = i.writeSchema()
+			if err != nil {
+				return nil, fmt.Errorf("while writing schema version to %s: %s", i.schemaPath, err)
+			}
+
+			// The new index has not been built yet.
+			os.Remove(i.indexedPath)
+
 		} else {
 			// In case of any other filesystem error, abort operation.
 			return nil, fmt.Errorf("while accessing %s: %s", i.indexPath, err)
@@ -74,176 +303,603 @@ func New(basePath string) (*Index, error) {
 	} else {
 
 		// If index was statted ok, try to open it.
-		i.bleveIndex, err = open(i.indexPath)
-		if err != nil {
+		i.bleveIndex, err = open(i.indexPath, i.mapping.passphrase)
+		if err == ErrIndexLocked || err == ErrWrongPassphrase || err == ErrPassphraseRequired {
+			return nil, err
+		} else if err != nil {
 			return nil, fmt.Errorf("while opening index at %s: %s", i.indexPath, err)
 		}
 		i.version, err = i.readVersion()
 		if err != nil {
 			console.Log("index state file is broken: %s", err)
 		}
+		err = i.checkServer()
+		if err != nil {
+			console.Log("while checking index server at %s: %s", i.statePath, err)
+		}
+		i.lastIndexed, err = i.readLastIndexed()
+		if err != nil {
+			console.Log("index timestamp file is broken: %s", err)
+		}
+		i.checkScoring()
 	}
 
+	i.setDefaultFieldBoosts()
+	i.touch()
+
 	console.Log("Opened search index in %s", time.Since(timer).String())
 
+	i.checkSize()
+
 	return i, nil
 }
 
-// Close closes a Bleve index.
+// Close closes a Bleve index. The index must not be used afterwards; searches
+// made after closing the index return ErrIndexClosed. Closing an index which
+// is already closed does nothing.
 func (i *Index) Close() error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.closed || i.bleveIndex == nil {
+		i.closed = true
+		return nil
+	}
+	i.closed = true
 	return i.bleveIndex.Close()
 }
 
 // create creates a Bleve index at the given file system location.
-func create(path string) (bleve.Index, error) {
-	mapping, err := buildIndexMapping()
+func create(path string, options mappingOptions) (bleve.Index, error) {
+	mapping, err := newIndexMapping(options)
 	if err != nil {
-		return nil, fmt.Errorf("BUG: unable to create search index mapping: %s", err)
+		return nil, err
 	}
 
-	index, err := bleve.New(path, mapping)
+	index, err := newBleve(path, mapping, options.passphrase)
 	if err != nil {
 		return nil, fmt.Errorf("while creating search index %s: %s", path, err)
 	}
 
+	err = writeScoring(index, options.scoring)
+	if err != nil {
+		index.Close()
+		return nil, fmt.Errorf("while writing scoring model to search index %s: %s", path, err)
+	}
+
 	return index, nil
 }
 
-// open opens a Bleve index at the given file system location.
-func open(path string) (bleve.Index, error) {
-	index, err := bleve.Open(path)
+// open opens a Bleve index at the given file system location, decrypting it
+// with passphrase if it is encrypted; see Passphrase. ErrIndexLocked is
+// returned if the index is in use by another process, and ErrWrongPassphrase
+// or ErrPassphraseRequired if it cannot be decrypted.
+func open(path string, passphrase string) (bleve.Index, error) {
+	locked, err := isLocked(storePath(path))
 	if err != nil {
+		return nil, fmt.Errorf("while checking lock on search index %s: %s", path, err)
+	} else if locked {
+		return nil, ErrIndexLocked
+	}
+
+	index, err := openBleve(path, passphrase)
+	if err == ErrWrongPassphrase || err == ErrPassphraseRequired {
+		return nil, err
+	} else if err != nil {
 		return nil, fmt.Errorf("while opening search index %s: %s", path, err)
 	}
 
 	return index, nil
 }
 
+// storePath returns the path to the key/value store file of a Bleve index.
+func storePath(indexPath string) string {
+	return path.Join(indexPath, "store")
+}
+
 // Path returns the absolute path to where indexes and state for a specific MPD
-// server should be stored.
+// server should be stored, within the XDG cache directory.
 func Path(host, port string) string {
-	cacheDir := xdg.CacheDirectory()
-	return path.Join(cacheDir, host, port)
+	return PathWithBase(xdg.CacheDirectory(), host, port)
 }
 
-// SetVersion writes the MPD library version to the state file.
+// PathWithBase works like Path, but stores indexes within the given base
+// directory instead of the XDG cache directory.
+func PathWithBase(base, host, port string) string {
+	return path.Join(base, host, port)
+}
+
+// SetVersion writes the MPD library version to the state file, along with the
+// server address configured with Server. The file is replaced atomically, so
+// that it is never left empty or partially written.
 func (i *Index) SetVersion(version int) error {
-	file, err := os.Create(i.statePath)
-	if err != nil {
-		return err
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.setVersion(version)
+}
+
+// setVersion implements SetVersion. The caller must hold the write lock.
+func (i *Index) setVersion(version int) error {
+	if !i.memOnly {
+		str := fmt.Sprintf("%d\n", version)
+		if len(i.server) > 0 {
+			str += i.server + "\n"
+		}
+		err := writeFileAtomic(i.statePath, []byte(str))
+		if err != nil {
+			return err
+		}
 	}
-	defer file.Close()
-	str := fmt.Sprintf("%d\n", version)
-	file.WriteString(str)
 	i.version = version
 	return nil
 }
 
 // readVersion reads the MPD library version from the state file.
 func (i *Index) readVersion() (int, error) {
-	file, err := os.Open(i.statePath)
+	lines, err := i.readState()
 	if err != nil {
 		return 0, err
 	}
+
+	if len(lines) == 0 {
+		return 0, fmt.Errorf("No data in index mpd library state file")
+	}
+
+	return strconv.Atoi(lines[0])
+}
+
+// readState returns the non-empty lines of the state file. Surrounding
+// whitespace and byte order marks, which may have been added if the file
+// was edited by hand, are stripped from each line.
+func (i *Index) readState() ([]string, error) {
+	file, err := os.Open(i.statePath)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
 
+	lines := make([]string, 0)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		version, err := strconv.Atoi(scanner.Text())
-		if err != nil {
-			return 0, err
+		line := strings.TrimSpace(strings.Replace(scanner.Text(), "\ufeff", "", -1))
+		if len(line) > 0 {
+			lines = append(lines, line)
 		}
-		return version, nil
 	}
 
-	return 0, fmt.Errorf("No data in index mpd library state file")
+	return lines, scanner.Err()
+}
+
+// InvalidateVersion marks the index as out of date without touching the
+// indexed songs, so that it is rebuilt the next time it is synchronized with
+// the MPD library.
+func (i *Index) InvalidateVersion() error {
+	return i.SetVersion(INVALID_VERSION)
 }
 
 // Version returns the index version. It should correspond to the MPD library version.
 func (i *Index) Version() int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
 	return i.version
 }
 
 // Index the entire Songlist.
+//
+// The songs are indexed into a new index next to the live one, which is
+// swapped into place only after indexing completes. Searches made while
+// indexing is in progress use the old index, and if indexing fails or is
+// aborted, the old index is kept as-is.
+//
+// Only one full index may run at a time. If indexing is already in progress,
+// ErrIndexingInProgress is returned.
 func (i *Index) IndexFull(songs []*song.Song, shutdown <-chan int) error {
-	songChan := make(chan *song.Song, len(songs))
-	console.Log("Feeding all songs into song queue...")
-	for _, s := range songs {
-		songChan <- s
+	return i.indexFull(songs, shutdown, nil)
+}
+
+// IndexFullProgress works like IndexFull, but calls progress each time a
+// batch of songs has been written to the index, with the number of songs
+// written so far and the total number of songs. Progress may be nil.
+func (i *Index) IndexFullProgress(songs []*song.Song, progress func(done, total int)) error {
+	return i.indexFull(songs, make(chan int), progress)
+}
+
+// indexFull implements IndexFull and IndexFullProgress.
+func (i *Index) indexFull(songs []*song.Song, shutdown <-chan int, progress func(done, total int)) error {
+	if err := i.startIndexing(); err != nil {
+		return err
+	}
+	defer i.stopIndexing()
+
+	next, err := i.createNext()
+	if err != nil {
+		return err
+	}
+
+	if workers := i.IndexWorkers(); workers > 1 {
+		err = i.fullIndexParallel(next, songs, workers, shutdown, progress)
+	} else {
+		songChan := make(chan *song.Song, len(songs))
+		console.Log("Feeding all songs into song queue...")
+		for _, s := range songs {
+			songChan <- s
+		}
+		console.Log("Done feeding songs.")
+
+		err = i.fullIndex(next, songChan, shutdown, progress)
+	}
+	if err != nil {
+		i.discardNext(next)
+		return err
+	}
+
+	err = i.swap(next)
+	if err != nil {
+		return err
+	}
+
+	i.indexed()
+	return nil
+}
+
+// indexed is called after a full rebuild has been swapped in.
+func (i *Index) indexed() {
+	if err := i.setLastIndexed(time.Now()); err != nil {
+		console.Log("Unable to record time of indexing in %s: %s", i.indexedPath, err)
 	}
-	console.Log("Done feeding songs.")
-	return fullIndex(i.bleveIndex, songChan, shutdown)
+	i.checkSize()
+}
+
+// IndexFullContext works like IndexFull, but is aborted when the context is
+// cancelled, in which case the context error is returned. Cancellation is
+// checked between batches. As with IndexFull, an aborted index leaves the old
+// index data untouched; partially indexed songs are discarded.
+func (i *Index) IndexFullContext(ctx context.Context, songs []*song.Song) error {
+	return i.indexFullContext(ctx, songs, nil)
+}
+
+// indexFullContext implements IndexFullContext and IndexFullAsync.
+func (i *Index) indexFullContext(ctx context.Context, songs []*song.Song, progress func(done, total int)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	shutdown, stop := shutdownOnCancel(ctx)
+	defer stop()
+
+	err := i.indexFull(songs, shutdown, progress)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// shutdownOnCancel returns a shutdown channel, as taken by IndexFull, which
+// receives a message when the context is cancelled. The returned function
+// must be called to release resources once the channel is no longer used.
+func shutdownOnCancel(ctx context.Context) (<-chan int, func()) {
+	shutdown := make(chan int, 1)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			shutdown <- 0
+		case <-done:
+		}
+	}()
+
+	return shutdown, func() { close(done) }
+}
+
+// OnBatchCommitted registers a callback which is called by IndexFull each
+// time a batch of songs has been successfully written to the index. The
+// callback receives the number of songs written so far, and the total number
+// of songs. Pass nil to remove the callback.
+func (i *Index) OnBatchCommitted(callback func(done, total int)) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.onBatchCommitted = callback
+}
+
+// batchCommitted invokes the callback registered with OnBatchCommitted.
+func (i *Index) batchCommitted(done, total int) {
+	i.mutex.RLock()
+	callback := i.onBatchCommitted
+	i.mutex.RUnlock()
+	if callback != nil {
+		callback(done, total)
+	}
+}
+
+// IsIndexing returns true if a full index is in progress.
+func (i *Index) IsIndexing() bool {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.indexing
+}
+
+// startIndexing flags the index as being rebuilt, or returns
+// ErrIndexingInProgress if it already is.
+func (i *Index) startIndexing() error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.closed {
+		return ErrIndexClosed
+	}
+	if i.indexing {
+		return ErrIndexingInProgress
+	}
+	i.indexing = true
+	return nil
+}
+
+// stopIndexing clears the flag set by startIndexing.
+func (i *Index) stopIndexing() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.indexing = false
 }
 
 // fullIndex indexes a stream of songs. This process can be aborted by sending
-// a message on the shutdown channel.
-func fullIndex(index bleve.Index, songs <-chan *song.Song, shutdown <-chan int) error {
+// a message on the shutdown channel, and paused between batches using Pause.
+// If progress is not nil, it is called after each committed batch.
+func (i *Index) fullIndex(index bleve.Index, songs <-chan *song.Song, shutdown <-chan int, progress func(done, total int)) error {
 	var err error
 
 	count := 0
-	batch := make(chan int, 1)
+	committed := 0
 	size := len(songs)
 	console.Log("Start full index.")
 
-	// All operations are batched, currently INDEX_BATCH_SIZE are committed each iteration.
+	// All operations are batched, batchSize songs are committed each iteration.
+	batchSize := i.BatchSize()
 	b := index.NewBatch()
+	started := time.Now()
+
+	// flush commits the songs added to the batch since the last flush.
+	flush := func() error {
+		console.Log("Indexing songs %d/%d...", count, size)
+		err := index.Batch(b)
+		if err != nil {
+			console.Log("Failed to index songs %d-%d: %s", committed, count-1, err)
+			return err
+		}
+		i.batchMetrics(count-committed, started)
+		started = time.Now()
+		committed = count
+		i.batchCommitted(count, size)
+		if progress != nil {
+			progress(count, size)
+		}
+		b.Reset()
+		return nil
+	}
 
-outer:
 	for {
 		select {
-		case n := <-batch:
-			console.Log("Indexing songs %d/%d...", count, size)
-			index.Batch(b)
-			b.Reset()
-			if n < 0 {
-				break outer
-			}
 		case s := <-songs:
-			is := index_song.New(s)
-			err = b.Index(strconv.Itoa(count), is)
+			err = indexSong(b, count, s)
 			if err != nil {
 				return err
 			}
-			if count%INDEX_BATCH_SIZE == 0 {
-				batch <- count
-			}
 			count += 1
+			if count%batchSize != 0 {
+				continue
+			}
+			if err = flush(); err != nil {
+				return err
+			}
+			if !i.waitWhilePaused(shutdown) {
+				return fmt.Errorf("Aborting paused index batch at position %d", count)
+			}
 		case _ = <-shutdown:
 			return fmt.Errorf("Aborting index batch at position %d", count)
 		default:
-			batch <- -1
+			// All songs have been read.
+			if b.Size() > 0 {
+				if err = flush(); err != nil {
+					return err
+				}
+			}
+			console.Log("Finished indexing.")
+			return nil
 		}
 	}
+}
 
-	console.Log("Finished indexing.")
-
+// SetBatchSize sets the number of songs committed to the index at once while
+// indexing. Smaller batches use less memory, at the cost of slower indexing.
+// The default is INDEX_BATCH_SIZE.
+func (i *Index) SetBatchSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("Index batch size must be positive")
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.batchSize = size
 	return nil
 }
 
+// BatchSize returns the number of songs committed to the index at once.
+func (i *Index) BatchSize() int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.batchSize
+}
+
+// SetMaxResults limits the number of results returned by any search, so
+// that a broken caller cannot exhaust memory by requesting millions of
+// results. Larger requests are reduced to the limit, and a message is
+// logged. Internal scans that must see every song, such as Diff, Export,
+// Isolate and PositionsForField, are not limited. A limit of zero or less,
+// which is the default, disables the limit.
+func (i *Index) SetMaxResults(max int) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.maxResults = max
+}
+
+// MaxResults returns the largest number of results returned by a search, or
+// zero if there is no limit.
+func (i *Index) MaxResults() int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return utils.Max(0, i.maxResults)
+}
+
+// SetScoreThreshold sets the minimum score of search results. Results with a
+// lower score are discarded. Scores depend on both the query and the library,
+// so the best threshold varies; a threshold of zero or less disables the
+// cutoff and returns all hits. The default is SEARCH_SCORE_THRESHOLD.
+func (i *Index) SetScoreThreshold(threshold float64) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.scoreThreshold = threshold
+}
+
+// ScoreThreshold returns the minimum score of search results.
+func (i *Index) ScoreThreshold() float64 {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.scoreThreshold
+}
+
 // Query takes a Bleve search request and returns a songlist with all matching songs.
+//
+// Each hit is compared to the score threshold on its own. If the request
+// sorts hits by anything other than descending score, the threshold is not
+// applied, and all hits are returned in the requested order.
+//
+// Requests for zero results or less return up to SEARCH_RESULT_SIZE results,
+// subject to the limit set by SetMaxResults.
 func (i *Index) Query(request *bleve.SearchRequest) ([]int, *bleve.SearchResult, error) {
-	//request.Size = 1000
+	return i.query(request, i.ScoreThreshold(), nil)
+}
+
+// query executes a Bleve search request, discarding hits that score below
+// threshold. If profile is not nil, the time spent searching and collecting
+// results is recorded into it.
+func (i *Index) query(request *bleve.SearchRequest, threshold float64, profile *QueryProfile) ([]int, *bleve.SearchResult, error) {
+	scored, sr, err := i.queryScored(request, threshold, profile)
+	r := make([]int, len(scored))
+	for n := range scored {
+		r[n] = scored[n].Pos
+	}
+	return r, sr, err
+}
 
+// queryScored works like query, but keeps the score of each hit.
+func (i *Index) queryScored(request *bleve.SearchRequest, threshold float64, profile *QueryProfile) ([]ScoredPosition, *bleve.SearchResult, error) {
+	return i.execute(i.limitRequest(request), threshold, profile)
+}
+
+// queryAll executes a Bleve search request exactly as given, without a score
+// threshold, a default size, or the limit set by SetMaxResults. It is used
+// by internal scans which must see every matching document, and should set
+// the request size accordingly, e.g. to DocCount.
+func (i *Index) queryAll(request *bleve.SearchRequest) ([]int, *bleve.SearchResult, error) {
+	scored, sr, err := i.execute(request, 0, nil)
+	r := make([]int, len(scored))
+	for n := range scored {
+		r[n] = scored[n].Pos
+	}
+	return r, sr, err
+}
+
+// limitRequest returns a copy of a search request made through one of the
+// public search functions. Requests for zero results or less get
+// SEARCH_RESULT_SIZE results, and the size is limited to the value set by
+// SetMaxResults. The given request is not modified.
+func (i *Index) limitRequest(request *bleve.SearchRequest) *bleve.SearchRequest {
+	limited := *request
+	if limited.Size <= 0 {
+		limited.Size = SEARCH_RESULT_SIZE
+	}
+	if max := i.MaxResults(); max > 0 && limited.Size > max {
+		console.Log("Limiting search request for %d results to %d results", limited.Size, max)
+		limited.Size = max
+	}
+	return &limited
+}
+
+// execute runs a Bleve search request, and collects the positions and scores
+// of hits scoring at least threshold.
+func (i *Index) execute(request *bleve.SearchRequest, threshold float64, profile *QueryProfile) ([]ScoredPosition, *bleve.SearchResult, error) {
+	timer := time.Now()
+	i.mutex.RLock()
+	if i.closed {
+		i.mutex.RUnlock()
+		return make([]ScoredPosition, 0), nil, ErrIndexClosed
+	}
 	sr, err := i.bleveIndex.Search(request)
+	i.mutex.RUnlock()
+	if profile != nil {
+		profile.Search = time.Since(timer)
+	}
 
 	if err != nil {
-		return make([]int, 0), nil, err
+		return make([]ScoredPosition, 0), nil, err
 	}
 
-	r := make([]int, 0, len(sr.Hits))
+	timer = time.Now()
+	r := make([]ScoredPosition, 0, len(sr.Hits))
+
+	// Scores are only comparable to the threshold when hits are ordered by
+	// relevance, and scored as full-text matches. Otherwise, return all hits.
+	if !sortedByScore(request) || !scoredByRelevance(request.Query) {
+		threshold = 0
+	}
+
+	// An inconsistent index may return several hits for the same position.
+	// Only the first is kept, with the highest score of all of them.
+	seen := make(map[int]int, len(sr.Hits))
 
 	for _, hit := range sr.Hits {
-		if hit.Score < SEARCH_SCORE_THRESHOLD {
-			break
+		if hit.Score < threshold {
+			continue
 		}
-		id, err := strconv.Atoi(hit.ID)
+		id, err := hitPosition(hit)
 		if err != nil {
-			return r, nil, fmt.Errorf("Index is corrupt; error when converting index IDs to integer: %s", err)
+			return r, nil, err
+		}
+		if n, ok := seen[id]; ok {
+			console.Log("Search index is inconsistent; document '%s' duplicates position %d", hit.ID, id)
+			if hit.Score > r[n].Score {
+				r[n].Score = hit.Score
+			}
+			continue
 		}
-		r = append(r, id)
+		seen[id] = len(r)
+		r = append(r, ScoredPosition{Pos: id, Score: hit.Score})
 	}
 
-	console.Log("Query '%v' returned %d results over threshold of %.2f (total %d results) in %s", request, len(r), SEARCH_SCORE_THRESHOLD, sr.Total, sr.Took)
+	if profile != nil {
+		profile.Collect = time.Since(timer)
+	}
+
+	i.queryMetrics(len(r), sr.Total, sr.Took)
+
+	console.Log("Query '%v' returned %d results over threshold of %.2f (total %d results) in %s", request, len(r), threshold, sr.Total, sr.Took)
 
 	return r, sr, nil
 }
+
+// sortedByScore returns true if a search request orders its hits by
+// descending relevance, which is the Bleve default.
+func sortedByScore(request *bleve.SearchRequest) bool {
+	if len(request.Sort) != 1 {
+		return false
+	}
+	score, ok := request.Sort[0].(*search.SortScore)
+	return ok && score.Desc
+}
+
+// hitPosition returns the songlist position of a search hit.
+func hitPosition(hit *search.DocumentMatch) (int, error) {
+	id, err := strconv.Atoi(hit.ID)
+	if err != nil {
+		return 0, fmt.Errorf("Index is corrupt; error when converting index IDs to integer: %s", err)
+	}
+	return id, nil
+}
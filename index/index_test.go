@@ -0,0 +1,2633 @@
+package index_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ambientsound/gompd/mpd"
+	"github.com/ambientsound/pms/index"
+	indexsong "github.com/ambientsound/pms/index/song"
+	"github.com/ambientsound/pms/song"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSong returns a song with the given tags.
+func newSong(tags mpd.Attrs) *song.Song {
+	s := song.New()
+	s.SetTags(tags)
+	return s
+}
+
+// newTestIndex creates an in-memory index containing the given songs. The
+// returned function closes the index.
+func newTestIndex(t *testing.T, songs []*song.Song, options ...index.Option) (*index.Index, func()) {
+	idx, err := index.NewInMemory(options...)
+	require.Nil(t, err)
+
+	err = idx.IndexFull(songs, make(chan int))
+	require.Nil(t, err)
+
+	return idx, func() {
+		idx.Close()
+	}
+}
+
+// newDiskIndex works like newTestIndex, but creates the index in a temporary
+// directory, which is removed by the returned function.
+func newDiskIndex(t *testing.T, songs []*song.Song, options ...index.Option) (*index.Index, func()) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+
+	idx, err := index.New(dir, options...)
+	require.Nil(t, err)
+
+	err = idx.IndexFull(songs, make(chan int))
+	require.Nil(t, err)
+
+	return idx, func() {
+		idx.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+var testSongs = []*song.Song{
+	newSong(mpd.Attrs{"file": "beatles/help.flac", "artist": "The Beatles", "title": "Help"}),
+	newSong(mpd.Attrs{"file": "beatles/yesterday.flac", "artist": "The Beatles", "title": "Yesterday"}),
+	newSong(mpd.Attrs{"file": "misc/yesterdays.flac", "artist": "Guns N' Roses", "title": "Yesterdays"}),
+}
+
+// BenchmarkIndexFullEncrypted compares indexing into an encrypted index on
+// disk with an unencrypted one.
+func BenchmarkIndexFullEncrypted(b *testing.B) {
+	songs := numberedSongs(200)
+	for _, passphrase := range []string{"", "secret"} {
+		b.Run(fmt.Sprintf("encrypted=%t", len(passphrase) > 0), func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "pms-index-bench")
+			require.Nil(b, err)
+			defer os.RemoveAll(dir)
+			idx, err := index.New(dir, index.Passphrase(passphrase))
+			require.Nil(b, err)
+			defer idx.Close()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				require.Nil(b, idx.IndexFull(songs, make(chan int)))
+			}
+		})
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// storeContains returns true if the raw store file contains s.
+	storeContains := func(s string) bool {
+		data, err := ioutil.ReadFile(path.Join(dir, "index", "store"))
+		require.Nil(t, err)
+		return bytes.Contains(data, []byte(s))
+	}
+
+	idx, err := index.New(dir, index.Passphrase("secret"))
+	require.Nil(t, err)
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	require.Nil(t, idx.SetVersion(7))
+
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+	value, err := idx.FieldValue(2, "artist")
+	require.Nil(t, err)
+	assert.Equal(t, "Guns N' Roses", value)
+	require.Nil(t, idx.Optimize())
+	require.Nil(t, idx.Close())
+
+	// Stored values are not readable, and the passphrase is not saved.
+	assert.False(t, storeContains("Guns N' Roses"))
+	meta, err := ioutil.ReadFile(path.Join(dir, "index", "index_meta.json"))
+	require.Nil(t, err)
+	assert.NotContains(t, string(meta), "secret")
+
+	// The index can only be opened with the right passphrase.
+	_, err = index.New(dir, index.Passphrase("wrong"))
+	assert.Equal(t, index.ErrWrongPassphrase, err)
+
+	idx, err = index.New(dir, index.Passphrase("secret"))
+	require.Nil(t, err)
+	assert.Equal(t, 7, idx.Version())
+	r, err = idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+	require.Nil(t, idx.Close())
+
+	// Without a passphrase, the index is kept, but cannot be opened.
+	_, err = index.New(dir)
+	assert.Equal(t, index.ErrPassphraseRequired, err)
+	idx, err = index.New(dir, index.Passphrase("secret"))
+	require.Nil(t, err)
+	assert.Equal(t, 7, idx.Version())
+	require.Nil(t, idx.Close())
+
+	// An unencrypted index is recreated encrypted.
+	require.Nil(t, os.RemoveAll(dir))
+	idx, err = index.New(dir)
+	require.Nil(t, err)
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	require.Nil(t, idx.SetVersion(7))
+	require.Nil(t, idx.Close())
+	assert.True(t, storeContains("Guns N' Roses"))
+	idx, err = index.New(dir, index.Passphrase("secret"))
+	require.Nil(t, err)
+	assert.Equal(t, 0, idx.Version())
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	require.Nil(t, idx.Close())
+	assert.False(t, storeContains("Guns N' Roses"))
+}
+
+func TestSearchByEditDistance(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.SearchByEditDistance("yesterday", "title", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, r)
+
+	r, err = idx.SearchByEditDistance("yesterdays", "title", 1)
+	require.Nil(t, err)
+	assert.Equal(t, []int{2}, r)
+
+	r, err = idx.SearchByEditDistance("yesterday", "title", 0)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, r)
+
+	r, err = idx.SearchByEditDistance("yesterday", "title", -1)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, r)
+
+	_, err = idx.SearchByEditDistance("yesterday", "nonexistent", 10)
+	assert.NotNil(t, err)
+}
+
+func TestSearchBool(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "comment": "la la la"}),
+		newSong(mpd.Attrs{"file": "b.flac", "comment": "  "}),
+		newSong(mpd.Attrs{"file": "c.flac"}),
+		newSong(mpd.Attrs{"file": "d.flac", "lyrics": "na na na"}),
+		newSong(mpd.Attrs{"file": "e.flac", "lyrics": ""}),
+		newSong(mpd.Attrs{"file": "f.flac", "lyrics": "", "comment": "la la la"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.SearchBool("has_lyrics", true, 10)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []int{0, 3, 5}, r)
+
+	// Empty tags mean that there are no lyrics, while absent tags mean
+	// that it is unknown, and match neither value.
+	r, err = idx.SearchBool("has_lyrics", false, 10)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 4}, r)
+
+	value, err := idx.FieldValue(4, "has_lyrics")
+	require.Nil(t, err)
+	assert.Equal(t, "false", value)
+}
+
+func TestFieldValue(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	value, err := idx.FieldValue(1, "title")
+	require.Nil(t, err)
+	assert.Equal(t, "Yesterday", value)
+
+	_, err = idx.FieldValue(1, "has_lyrics")
+	assert.Equal(t, index.ErrNotFound, err)
+
+	_, err = idx.FieldValue(1, "album")
+	assert.Equal(t, index.ErrNotFound, err)
+
+	_, err = idx.FieldValue(100, "title")
+	assert.Equal(t, index.ErrNotFound, err)
+}
+
+func TestStoredFields(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs, index.StoredFields("title"))
+	defer cleanup()
+
+	value, err := idx.FieldValue(0, "title")
+	require.Nil(t, err)
+	assert.Equal(t, "Help", value)
+
+	_, err = idx.FieldValue(0, "artist")
+	assert.Equal(t, index.ErrNotFound, err)
+}
+
+func TestSearch(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.Search("  beatles   help ", 10)
+	require.Nil(t, err)
+	require.NotEmpty(t, r)
+	assert.Equal(t, 0, r[0])
+}
+
+func TestMaxIndexSize(t *testing.T) {
+	var warned int64
+	idx, cleanup := newDiskIndex(t, testSongs, index.MaxIndexSize(1, func(size int64) {
+		warned = size
+	}))
+	defer cleanup()
+
+	assert.True(t, warned > 1)
+
+	// Incremental updates are checked too.
+	warned = 0
+	require.Nil(t, idx.IndexPartial(testSongs, []int{0}))
+	assert.True(t, warned > 1)
+
+	warned = 0
+	require.Nil(t, idx.DeleteAt([]int{0}))
+	assert.True(t, warned > 1)
+
+	warned = 0
+	tx := idx.Begin()
+	require.Nil(t, tx.Index(0, testSongs[0]))
+	require.Nil(t, tx.Commit())
+	assert.True(t, warned > 1)
+}
+
+func TestSameAlbum(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "1.flac", "album": "Greatest Hits", "albumartist": "Queen", "title": "Bohemian Rhapsody"}),
+		newSong(mpd.Attrs{"file": "2.flac", "album": "Greatest Hits", "albumartist": "ABBA", "title": "Waterloo"}),
+		newSong(mpd.Attrs{"file": "3.flac", "album": "greatest hits", "albumartist": "queen", "title": "Somebody to Love"}),
+		newSong(mpd.Attrs{"file": "4.flac", "album": "Greatest Hits II", "albumartist": "Queen", "title": "Innuendo"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.SameAlbum(songs[0], 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 2}, r)
+
+	_, err = idx.SameAlbum(newSong(mpd.Attrs{"file": "5.flac"}), 10)
+	assert.NotNil(t, err)
+}
+
+func TestSameAlbumSize(t *testing.T) {
+	songs := make([]*song.Song, 20)
+	for n := range songs {
+		songs[n] = newSong(mpd.Attrs{
+			"file":  fmt.Sprintf("%d.flac", n),
+			"album": "Greatest Hits",
+			"track": fmt.Sprintf("%d", n+1),
+		})
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	// The first tracks of the album are returned, not the top hits.
+	r, err := idx.SameAlbum(songs[0], 5)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, r)
+}
+
+func TestCustomizeMapping(t *testing.T) {
+	r := func(idx *index.Index) []int {
+		idx.SetScoreThreshold(0)
+		r, err := idx.SearchField("title", "yes", 10)
+		require.Nil(t, err)
+		return r
+	}
+
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+	assert.Equal(t, []int{1, 2}, r(idx))
+
+	// Index titles as a single, case sensitive term.
+	customize := index.CustomizeMapping(func(m *mapping.IndexMappingImpl) error {
+		m.DefaultMapping.Properties["Title"].Fields[0].Analyzer = "keyword"
+		return nil
+	})
+	idx, cleanup = newTestIndex(t, testSongs, customize)
+	defer cleanup()
+	assert.Empty(t, r(idx))
+
+	failing := index.CustomizeMapping(func(m *mapping.IndexMappingImpl) error {
+		return fmt.Errorf("failed")
+	})
+	_, err := index.NewInMemory(failing)
+	assert.NotNil(t, err)
+}
+
+func TestNewWithMappingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mappingPath := path.Join(dir, "mapping.json")
+
+	// A missing mapping file falls back to the built-in mapping.
+	idx, err := index.NewWithMappingFile(path.Join(dir, "missing"), mappingPath)
+	require.Nil(t, err)
+	idx.Close()
+
+	// A valid mapping file is used.
+	err = ioutil.WriteFile(mappingPath, []byte(`{"default_analyzer": "standard"}`), 0644)
+	require.Nil(t, err)
+	idx, err = index.NewWithMappingFile(path.Join(dir, "valid"), mappingPath)
+	require.Nil(t, err)
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	r, err := idx.Search("yesterday", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1}, r)
+
+	// Internal fields are mapped as in the built-in mapping.
+	hash := indexsong.New(testSongs[1]).Hash
+	_, sr, err := idx.Query(bleve.NewSearchRequest(bleve.NewQueryStringQuery(hash)))
+	require.Nil(t, err)
+	assert.Equal(t, uint64(0), sr.Total)
+	added, removed, changed, err := idx.Diff(testSongs)
+	require.Nil(t, err)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+	assert.Empty(t, changed)
+	idx.Close()
+
+	// An invalid mapping file is an error.
+	err = ioutil.WriteFile(mappingPath, []byte(`{"default_analyzer": "nonexistent"}`), 0644)
+	require.Nil(t, err)
+	_, err = index.NewWithMappingFile(path.Join(dir, "invalid"), mappingPath)
+	assert.NotNil(t, err)
+}
+
+func TestIndexFullReplacesIndex(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "kraftwerk/autobahn.flac", "artist": "Kraftwerk", "title": "Autobahn"}),
+		newSong(mpd.Attrs{"file": "neu/hallogallo.flac", "artist": "Neu!", "title": "Hallogallo"}),
+		newSong(mpd.Attrs{"file": "can/vitamin.flac", "artist": "Can", "title": "Vitamin C"}),
+	}
+	require.Nil(t, idx.IndexFull(songs, make(chan int)))
+
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+
+	r, err = idx.Search("kraftwerk", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+}
+
+func TestSetRecentlyPlayed(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	idx.SetRecentlyPlayed([]string{"beatles/yesterday.flac"})
+	r, err = idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1, 0}, r)
+}
+
+func TestPositionsForField(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.PositionsForField("artist", "the beatles")
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	r, err = idx.PositionsForField("artist", "beatles")
+	require.Nil(t, err)
+	assert.Empty(t, r)
+}
+
+func TestPauseResume(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	// Commit one song per batch, so that indexing pauses after the first.
+	require.Nil(t, idx.SetBatchSize(1))
+	idx.Pause()
+	assert.True(t, idx.Paused())
+
+	done := make(chan error)
+	go func() {
+		done <- idx.IndexFull(testSongs, make(chan int))
+	}()
+
+	// Searches keep working while indexing is paused.
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	select {
+	case <-done:
+		t.Fatalf("Indexing finished while paused")
+	default:
+	}
+
+	idx.Resume()
+	assert.False(t, idx.Paused())
+	assert.Nil(t, <-done)
+}
+
+func TestPrefixQueriesIgnoreThreshold(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	prefix := bleve.NewPrefixQuery("yest")
+	prefix.SetField("Title")
+	r, _, err := idx.Query(bleve.NewSearchRequest(prefix))
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 2}, r)
+
+	wildcard := bleve.NewWildcardQuery("*terday*")
+	wildcard.SetField("Title")
+	r, _, err = idx.Query(bleve.NewSearchRequest(wildcard))
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 2}, r)
+
+	r, err = idx.Search("yesterday~1", 10)
+	require.Nil(t, err)
+	assert.Subset(t, r, []int{1, 2})
+}
+
+func TestInvalidateVersion(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	require.Nil(t, idx.SetVersion(1234))
+	require.Nil(t, idx.InvalidateVersion())
+	assert.Equal(t, index.INVALID_VERSION, idx.Version())
+
+	// The indexed songs are untouched.
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+}
+
+func TestSearchUnder(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "Artist/Album/01.flac"}),
+		newSong(mpd.Attrs{"file": "Artist/Album/02.flac"}),
+		newSong(mpd.Attrs{"file": "Artist/Other Album/01.flac"}),
+		newSong(mpd.Attrs{"file": "flat.flac"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.SearchUnder("Artist/Album/")
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	r, err = idx.SearchUnder("artist")
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 2}, r)
+
+	value, err := idx.FieldValue(2, "directory")
+	require.Nil(t, err)
+	assert.Equal(t, "Artist/Other Album", value)
+
+	_, err = idx.FieldValue(3, "directory")
+	assert.Equal(t, index.ErrNotFound, err)
+}
+
+func TestSearchProfile(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, profile, err := idx.SearchProfile("beatles", 10)
+	require.Nil(t, err)
+	expected, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, expected, r)
+
+	assert.True(t, profile.Search > 0)
+	assert.True(t, profile.Collect > 0)
+	assert.True(t, profile.Total >= profile.Search)
+	assert.True(t, profile.Total >= profile.Normalize+profile.Search+profile.Collect)
+}
+
+func TestSearchScored(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.SearchScored("beatles", 10)
+	require.Nil(t, err)
+	require.Len(t, r, 2)
+	assert.Equal(t, 0, r[0].Pos)
+	assert.Equal(t, 1, r[1].Pos)
+	assert.True(t, r[0].Score >= r[1].Score)
+	assert.True(t, r[1].Score >= index.SEARCH_SCORE_THRESHOLD)
+
+	// Search applies the same threshold, and returns the same positions.
+	for _, threshold := range []float64{0, index.SEARCH_SCORE_THRESHOLD, 0.6} {
+		idx.SetScoreThreshold(threshold)
+		for _, q := range []string{"beatles", "yesterday"} {
+			scored, err := idx.SearchScored(q, 10)
+			require.Nil(t, err)
+			positions, err := idx.Search(q, 10)
+			require.Nil(t, err)
+			require.Len(t, positions, len(scored))
+			for n := range scored {
+				assert.Equal(t, scored[n].Pos, positions[n])
+			}
+		}
+	}
+}
+
+func TestIndexFullConcurrently(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	assert.False(t, idx.IsIndexing())
+
+	// Hold the first indexing job at its first batch boundary.
+	require.Nil(t, idx.SetBatchSize(1))
+	idx.Pause()
+	done := make(chan error)
+	go func() {
+		done <- idx.IndexFull(testSongs, make(chan int))
+	}()
+	for !idx.IsIndexing() {
+		time.Sleep(time.Millisecond)
+	}
+
+	err := idx.IndexFull(testSongs, make(chan int))
+	assert.Equal(t, index.ErrIndexingInProgress, err)
+
+	idx.Resume()
+	assert.Nil(t, <-done)
+	assert.False(t, idx.IsIndexing())
+}
+
+func TestSearchUnified(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.SearchUnified("beatles", map[int]bool{1: true, 2: true}, 10)
+	require.Nil(t, err)
+	require.Len(t, r, 2)
+	assert.Equal(t, 0, r[0].Pos)
+	assert.Equal(t, index.SourceLibrary, r[0].Source)
+	assert.Equal(t, 1, r[1].Pos)
+	assert.Equal(t, index.SourceLibrary|index.SourceQueue, r[1].Source)
+
+	// Queue hits are found even if library hits fill up the result size.
+	r, err = idx.SearchUnified("beatles", map[int]bool{1: true}, 1)
+	require.Nil(t, err)
+	require.Len(t, r, 2)
+	assert.Equal(t, index.SourceLibrary, r[0].Source)
+	assert.Equal(t, index.SourceQueue, r[1].Source)
+
+	// Queue hits are scored like library hits.
+	library, err := idx.SearchScored("beatles", 10)
+	require.Nil(t, err)
+	require.Len(t, library, 2)
+	assert.Equal(t, library[1].Pos, r[1].Pos)
+	assert.Equal(t, library[1].Score, r[1].Score)
+}
+
+func TestCompleteField(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.CompleteField("title", "Yes", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"yesterday", "yesterdays"}, r)
+
+	r, err = idx.CompleteField("artist", "", 1)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"guns n' roses"}, r)
+}
+
+func TestSuggest(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.Suggest("title", "yes", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"Yesterday", "Yesterdays"}, r)
+
+	r, err = idx.Suggest("artist", "", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"Guns N' Roses", "The Beatles"}, r)
+
+	r, err = idx.Suggest("artist", "", 1)
+	require.Nil(t, err)
+	assert.Len(t, r, 1)
+}
+
+func TestIndexSet(t *testing.T) {
+	a, cleanupA := newTestIndex(t, testSongs)
+	defer cleanupA()
+	b, cleanupB := newTestIndex(t, testSongs[2:])
+	defer cleanupB()
+	closed, cleanupClosed := newTestIndex(t, testSongs)
+	defer cleanupClosed()
+	require.Nil(t, closed.Close())
+
+	set := index.NewIndexSet(a, b)
+	r, err := set.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, map[*index.Index][]int{a: {0, 1}, b: {}}, r)
+
+	set = index.NewIndexSet(a, closed)
+	r, err = set.Search("beatles", 10)
+	require.IsType(t, index.SetError{}, err)
+	assert.Equal(t, index.ErrIndexClosed, err.(index.SetError)[closed])
+	assert.Equal(t, map[*index.Index][]int{a: {0, 1}}, r)
+}
+
+func TestSearchRegex(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.SearchRegex("title", "^Yes", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, r)
+
+	r, err = idx.SearchRegex("title", "days?$", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, r)
+
+	r, err = idx.SearchRegex("artist", "n' r", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{2}, r)
+
+	r, err = idx.SearchRegex("title", "^elp", 10)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+
+	_, err = idx.SearchRegex("title", "(unclosed", 10)
+	assert.NotNil(t, err)
+}
+
+func TestFacet(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Genre": "Rock"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Genre": "rock"}),
+		newSong(mpd.Attrs{"file": "c.flac", "Genre": "Hard Rock"}),
+		newSong(mpd.Attrs{"file": "d.flac", "Genre": "Jazz"}),
+		newSong(mpd.Attrs{"file": "e.flac", "Genre": "Rock"}),
+		newSong(mpd.Attrs{"file": "f.flac"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.Facet("genre", 2)
+	require.Nil(t, err)
+	require.Len(t, r, 2)
+	assert.Equal(t, "Rock", r[0].Value)
+	assert.Equal(t, 3, r[0].Count)
+	assert.Equal(t, 1, r[1].Count)
+
+	r, err = idx.Facet("genre", 10)
+	require.Nil(t, err)
+	assert.Len(t, r, 3)
+
+	_, err = idx.Facet("nonexistent", 10)
+	assert.NotNil(t, err)
+}
+
+func TestFacets(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.Facets("beatles", []string{"artist", "title"})
+	require.Nil(t, err)
+	require.Len(t, r, 2)
+	assert.Equal(t, "artist", r[0].Field)
+	assert.Equal(t, []index.FacetTerm{{Value: "The Beatles", Count: 2}}, r[0].Terms)
+	assert.Equal(t, "title", r[1].Field)
+	assert.Len(t, r[1].Terms, 2)
+
+	// Only the songs returned by the search are counted.
+	sr, err := idx.SearchWith("beatles", index.SearchOptions{Size: 1, Facets: []string{"artist", "title"}})
+	require.Nil(t, err)
+	require.Len(t, sr.Positions, 1)
+	require.Len(t, sr.Facets, 2)
+	assert.Equal(t, []index.FacetTerm{{Value: "The Beatles", Count: 1}}, sr.Facets[0].Terms)
+	assert.Equal(t, []index.FacetTerm{{Value: testSongs[sr.Positions[0]].StringTags["title"], Count: 1}}, sr.Facets[1].Terms)
+
+	idx.SetScoreThreshold(100)
+	r, err = idx.Facets("beatles", []string{"artist"})
+	require.Nil(t, err)
+	assert.Len(t, r[0].Terms, 0)
+	idx.SetScoreThreshold(index.SEARCH_SCORE_THRESHOLD)
+
+	r, err = idx.Facets("nonexistent", []string{"artist"})
+	require.Nil(t, err)
+	assert.Len(t, r[0].Terms, 0)
+
+	_, err = idx.Facets("beatles", []string{"nonexistent"})
+	assert.NotNil(t, err)
+}
+
+func TestSimilar(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Artist": "Blur", "Album": "Parklife", "Genre": "Britpop"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Artist": "Blur", "Album": "Parklife", "Genre": "Britpop"}),
+		newSong(mpd.Attrs{"file": "c.flac", "Artist": "Oasis", "Album": "Definitely Maybe", "Genre": "Britpop"}),
+		newSong(mpd.Attrs{"file": "d.flac", "Artist": "Blur", "Album": "13", "Genre": "Alternative"}),
+		newSong(mpd.Attrs{"file": "e.flac", "Artist": "Miles Davis", "Album": "Kind of Blue", "Genre": "Jazz"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.Similar(songs[0], 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1, 3, 2}, r)
+
+	r, err = idx.Similar(songs[0], 1)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1}, r)
+
+	_, err = idx.Similar(newSong(mpd.Attrs{"file": "x.flac"}), 10)
+	assert.NotNil(t, err)
+}
+
+func TestSimilarWords(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Artist": "Pixies", "Genre": "Rock; Alternative"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Artist": "Queen", "Genre": "Rock"}),
+		newSong(mpd.Attrs{"file": "c.flac", "Artist": "Blur", "Genre": "Alternative"}),
+		newSong(mpd.Attrs{"file": "d.flac", "Artist": "Miles Davis", "Genre": "Jazz"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.Similar(songs[0], 10)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 2}, r)
+
+	r, err = idx.Similar(songs[1], 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+}
+
+func TestTransaction(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	tx := idx.Begin()
+	require.Nil(t, tx.Delete(0))
+	require.Nil(t, tx.Index(1, newSong(mpd.Attrs{"file": "beatles/yesterday.flac", "artist": "The Beatles", "title": "Let It Be"})))
+	tx.Rollback()
+	assert.Equal(t, index.ErrTransactionDone, tx.Commit())
+
+	value, err := idx.FieldValue(1, "title")
+	require.Nil(t, err)
+	assert.Equal(t, "Yesterday", value)
+
+	tx = idx.Begin()
+	require.Nil(t, tx.Delete(0))
+	require.Nil(t, tx.Index(1, newSong(mpd.Attrs{"file": "beatles/yesterday.flac", "artist": "The Beatles", "title": "Let It Be"})))
+	assert.Equal(t, 2, tx.Len())
+	require.Nil(t, tx.Commit())
+
+	_, err = idx.FieldValue(0, "title")
+	assert.Equal(t, index.ErrNotFound, err)
+	value, err = idx.FieldValue(1, "title")
+	require.Nil(t, err)
+	assert.Equal(t, "Let It Be", value)
+}
+
+func TestDiff(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	added, removed, changed, err := idx.Diff(testSongs)
+	require.Nil(t, err)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+	assert.Empty(t, changed)
+
+	songs := []*song.Song{
+		testSongs[0],
+		newSong(mpd.Attrs{"file": "beatles/yesterday.flac", "artist": "The Beatles", "title": "Yesterday (Remastered)"}),
+	}
+	added, removed, changed, err = idx.Diff(songs)
+	require.Nil(t, err)
+	assert.Empty(t, added)
+	assert.Equal(t, []int{2}, removed)
+	assert.Equal(t, []int{1}, changed)
+
+	songs = append(testSongs, newSong(mpd.Attrs{"file": "new.flac"}))
+	added, removed, changed, err = idx.Diff(songs)
+	require.Nil(t, err)
+	assert.Equal(t, []int{3}, added)
+	assert.Empty(t, removed)
+	assert.Empty(t, changed)
+}
+
+func TestOnBatchCommitted(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	done := 0
+	idx.OnBatchCommitted(func(d, total int) {
+		assert.Equal(t, len(testSongs), total)
+		done = d
+	})
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	assert.Equal(t, len(testSongs), done)
+}
+
+func TestDecade(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "1.flac", "date": "1987-05-01"}),
+		newSong(mpd.Attrs{"file": "2.flac", "date": "1980"}),
+		newSong(mpd.Attrs{"file": "3.flac", "date": "1999"}),
+		newSong(mpd.Attrs{"file": "4.flac", "date": "unknown"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.PositionsForField("decade", "1980s")
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	_, err = idx.FieldValue(3, "decade")
+	assert.Equal(t, index.ErrNotFound, err)
+}
+
+func TestIndexedTags(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs, index.IndexedTags("artist", "title"))
+	defer cleanup()
+
+	r, err := idx.PositionsForField("title", "help")
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+
+	_, err = idx.PositionsForField("album", "help")
+	assert.NotNil(t, err)
+
+	assert.True(t, idx.Indexed("title"))
+	assert.False(t, idx.Indexed("album"))
+	assert.False(t, idx.Indexed("nonexistent"))
+
+	r, err = idx.Search("beatles/help.flac", 10)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+}
+
+func TestRerunLast(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	_, err := idx.RerunLast("view")
+	assert.Equal(t, index.ErrNotFound, err)
+
+	r, err := idx.SearchRemember("view", "beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r.Positions)
+
+	tx := idx.Begin()
+	require.Nil(t, tx.Delete(0))
+	require.Nil(t, tx.Commit())
+
+	r, err = idx.RerunLast("view")
+	require.Nil(t, err)
+	assert.Equal(t, "beatles", r.Query)
+	assert.Equal(t, []int{1}, r.Positions)
+}
+
+func TestSubstringFallback(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.SearchWith("terday", index.SearchOptions{Size: 10})
+	require.Nil(t, err)
+	assert.Empty(t, r.Positions)
+
+	r, err = idx.SearchWith("terday", index.SearchOptions{Size: 10, SubstringFallback: true})
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 2}, r.Positions)
+}
+
+func TestSearchWith(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.SearchWith("beatles", index.SearchOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r.Positions)
+	assert.Equal(t, uint64(2), r.Total)
+
+	r, err = idx.SearchWith("beatles", index.SearchOptions{Size: 1, From: 1})
+	require.Nil(t, err)
+	assert.Len(t, r.Positions, 1)
+	assert.Equal(t, uint64(2), r.Total)
+
+	r, err = idx.SearchWith("beatles", index.SearchOptions{Sort: []string{"-title"}})
+	require.Nil(t, err)
+	assert.Equal(t, []int{1, 0}, r.Positions)
+
+	r, err = idx.SearchWith("yesterday", index.SearchOptions{Threshold: 100})
+	require.Nil(t, err)
+	assert.Empty(t, r.Positions)
+	r, err = idx.SearchWith("yesterday", index.SearchOptions{Threshold: -1, Field: "title"})
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 2}, r.Positions)
+
+	r, err = idx.SearchWith("help", index.SearchOptions{Highlight: true})
+	require.Nil(t, err)
+	require.Equal(t, []int{0}, r.Positions)
+	require.Len(t, r.Fragments, 1)
+	assert.Contains(t, r.Fragments[0]["title"][0], "<mark>Help</mark>")
+
+	_, err = idx.SearchWith("beatles", index.SearchOptions{Field: "nonexistent"})
+	assert.NotNil(t, err)
+}
+
+func TestSearchWithSpans(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "artist": "Beyoncé", "title": "Crazy in Love"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.SearchWith("beyonce", index.SearchOptions{Highlight: true, Threshold: -1})
+	require.Nil(t, err)
+	require.Equal(t, []int{0}, r.Positions)
+	require.Len(t, r.Spans, 1)
+	assert.Equal(t, []index.Span{{Start: 0, End: len("Beyoncé")}}, r.Spans[0]["artist"])
+
+	r, err = idx.SearchWith("crazy lov", index.SearchOptions{Highlight: true, Threshold: -1})
+	require.Nil(t, err)
+	require.Equal(t, []int{0}, r.Positions)
+	assert.Equal(t, []index.Span{{Start: 0, End: 5}, {Start: 9, End: 13}}, r.Spans[0]["title"])
+	assert.Empty(t, r.Spans[0]["artist"])
+}
+
+func TestScoringModel(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+	assert.Equal(t, index.SCORING_TFIDF, idx.Scoring())
+
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	assert.Equal(t, index.SCORING_TFIDF, idx.Scoring())
+
+	_, err := index.New(path.Join(os.TempDir(), "pms-index-bm25"), index.ScoringModel(index.SCORING_BM25))
+	assert.NotNil(t, err)
+}
+
+func TestCheckPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-checkpath")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, index.CheckPath(path.Join(dir, "cache")))
+
+	err = index.CheckPathSpace(dir, 1<<62)
+	require.NotNil(t, err)
+	assert.Equal(t, index.ErrInsufficientSpace, err.(*index.PathError).Err)
+}
+
+func TestSetFieldBoosts(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	assert.NotNil(t, idx.SetFieldBoosts(map[string]float64{"nonexistent": 2}))
+
+	boosted, err := idx.SearchScored("beatles", 10)
+	require.Nil(t, err)
+	require.Len(t, boosted, 2)
+
+	require.Nil(t, idx.SetFieldBoosts(nil))
+	before, err := idx.SearchScored("beatles", 10)
+	require.Nil(t, err)
+	require.Len(t, before, 2)
+
+	// Boosting only ever raises scores.
+	assert.True(t, boosted[0].Score > before[0].Score)
+
+	require.Nil(t, idx.SetFieldBoosts(map[string]float64{"artist": 4}))
+	after, err := idx.SearchScored("beatles", 10)
+	require.Nil(t, err)
+	require.Len(t, after, 2)
+	assert.True(t, after[0].Score > before[0].Score)
+}
+
+func TestDefaultFieldBoosts(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Artist": "Someone", "Genre": "Queen"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Artist": "Queen and the royal band of many words", "Genre": "Rock Pop Extra Words"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+	idx.SetScoreThreshold(0)
+
+	r, err := idx.Search("queen", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1, 0}, r)
+
+	require.Nil(t, idx.SetFieldBoosts(nil))
+	r, err = idx.Search("queen", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+}
+
+func TestGroupingAndMood(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Title": "Morning", "Mood": "Happy", "Grouping": "Sunday"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Title": "Evening", "Mood": "Melancholic"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.PositionsForField("mood", "happy")
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+
+	r, err = idx.Search("Mood:melancholic", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1}, r)
+
+	value, err := idx.FieldValue(0, "grouping")
+	require.Nil(t, err)
+	assert.Equal(t, "Sunday", value)
+}
+
+func TestSearchAll(t *testing.T) {
+	songs := make([]*song.Song, index.SEARCH_PAGE_SIZE*2+10)
+	for n := range songs {
+		songs[n] = newSong(mpd.Attrs{"file": fmt.Sprintf("%d.flac", n), "Artist": "Beatles"})
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	next, err := idx.SearchAll("beatles")
+	require.Nil(t, err)
+
+	seen := make(map[int]bool)
+	for pos, ok := next(); ok; pos, ok = next() {
+		seen[pos] = true
+	}
+	assert.Len(t, seen, len(songs))
+
+	_, ok := next()
+	assert.False(t, ok)
+}
+
+func TestSearchAllMaxResults(t *testing.T) {
+	songs := make([]*song.Song, index.SEARCH_PAGE_SIZE+10)
+	for n := range songs {
+		songs[n] = newSong(mpd.Attrs{"file": fmt.Sprintf("%d.flac", n), "Artist": "Beatles"})
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+	idx.SetMaxResults(10)
+
+	next, err := idx.SearchAll("beatles")
+	require.Nil(t, err)
+
+	seen := make(map[int]bool)
+	for pos, ok := next(); ok; pos, ok = next() {
+		seen[pos] = true
+	}
+	assert.Len(t, seen, len(songs))
+}
+
+func TestSearchAllDuplicates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	songs := make([]*song.Song, index.SEARCH_PAGE_SIZE*2+10)
+	for n := range songs {
+		songs[n] = newSong(mpd.Attrs{"file": fmt.Sprintf("%d.flac", n), "Artist": "Beatles"})
+	}
+	idx, err := index.New(dir)
+	require.Nil(t, err)
+	require.Nil(t, idx.IndexFull(songs, make(chan int)))
+	idx.Close()
+
+	// Add documents which duplicate existing positions under different IDs,
+	// scoring higher than the originals, so that the duplicates are found
+	// on the first page and the originals on later pages.
+	b, err := bleve.Open(path.Join(dir, "index"))
+	require.Nil(t, err)
+	for _, id := range []string{"0005", "0150", "0205"} {
+		s := newSong(mpd.Attrs{"file": id + ".flac", "Artist": "Beatles", "Title": "Beatles"})
+		require.Nil(t, b.Index(id, indexsong.New(s)))
+	}
+	require.Nil(t, b.Close())
+
+	idx, err = index.New(dir)
+	require.Nil(t, err)
+	defer idx.Close()
+
+	next, err := idx.SearchAll("beatles")
+	require.Nil(t, err)
+
+	seen := make(map[int]int)
+	for pos, ok := next(); ok; pos, ok = next() {
+		seen[pos]++
+	}
+	assert.Len(t, seen, len(songs))
+	for pos, count := range seen {
+		assert.Equal(t, 1, count, "position %d returned more than once", pos)
+	}
+}
+
+func TestIndexLocked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	idx, err := index.New(dir)
+	require.Nil(t, err)
+
+	_, err = index.New(dir)
+	assert.Equal(t, index.ErrIndexLocked, err)
+
+	idx.Close()
+	idx, err = index.New(dir)
+	require.Nil(t, err)
+	idx.Close()
+}
+
+func TestSetAlias(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	idx, err := index.New(dir)
+	require.Nil(t, err)
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+
+	assert.NotNil(t, idx.SetAlias("two words", "beatles"))
+	require.Nil(t, idx.SetAlias("fab", "  the   beatles "))
+
+	r, err := idx.Search("@fab", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	idx.Close()
+	idx, err = index.New(dir)
+	require.Nil(t, err)
+	defer idx.Close()
+	assert.Equal(t, map[string]string{"fab": "the beatles"}, idx.Aliases())
+
+	require.Nil(t, idx.SetAlias("@fab", ""))
+	assert.Empty(t, idx.Aliases())
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	idx, err := index.New(dir, index.HistorySize(3))
+	require.Nil(t, err)
+	assert.Empty(t, idx.History())
+
+	for _, q := range []string{"beatles", "  ", "help", "help", "yesterday", " the   beatles "} {
+		require.Nil(t, idx.AddHistory(q))
+	}
+	assert.Equal(t, []string{"help", "yesterday", "the beatles"}, idx.History())
+
+	// The history is remembered when the index is reopened.
+	idx.Close()
+	idx, err = index.New(dir, index.HistorySize(2))
+	require.Nil(t, err)
+	defer idx.Close()
+	assert.Equal(t, []string{"yesterday", "the beatles"}, idx.History())
+}
+
+func TestIndexPartial(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs[:2])
+	defer cleanup()
+
+	assert.NotNil(t, idx.IndexPartial(testSongs, []int{3}))
+
+	require.Nil(t, idx.IndexPartial(testSongs, []int{2}))
+	value, err := idx.FieldValue(2, "title")
+	require.Nil(t, err)
+	assert.Equal(t, "Yesterdays", value)
+
+	require.Nil(t, idx.DeleteAt([]int{0, 2}))
+	_, err = idx.FieldValue(2, "title")
+	assert.Equal(t, index.ErrNotFound, err)
+	value, err = idx.FieldValue(1, "title")
+	require.Nil(t, err)
+	assert.Equal(t, "Yesterday", value)
+}
+
+func TestDeleteAtBatches(t *testing.T) {
+	songs := numberedSongs(10)
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+	require.Nil(t, idx.SetBatchSize(3))
+
+	positions := make([]int, len(songs))
+	for n := range positions {
+		positions[n] = n
+	}
+	require.Nil(t, idx.DeleteAt(positions))
+
+	count, err := idx.DocCount()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(0), count)
+}
+
+func TestUpdatesDuringRebuild(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	// Hold a rebuild at its first batch boundary.
+	require.Nil(t, idx.SetBatchSize(1))
+	idx.Pause()
+	done := make(chan error)
+	go func() {
+		done <- idx.IndexFull(testSongs, make(chan int))
+	}()
+	for !idx.IsIndexing() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Changes made now would be lost when the rebuilt index is swapped in.
+	assert.Equal(t, index.ErrIndexingInProgress, idx.IndexPartial(testSongs, []int{0}))
+	assert.Equal(t, index.ErrIndexingInProgress, idx.DeleteAt([]int{0}))
+	tx := idx.Begin()
+	require.Nil(t, tx.Delete(0))
+	assert.Equal(t, index.ErrIndexingInProgress, tx.Commit())
+
+	idx.Resume()
+	require.Nil(t, <-done)
+
+	// The transaction can be committed once the rebuild is done.
+	require.Nil(t, tx.Commit())
+	_, err := idx.FieldValue(0, "title")
+	assert.Equal(t, index.ErrNotFound, err)
+}
+
+func TestSearchPhrase(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Album": "The Dark Side of the Moon", "Title": "Time"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Album": "The Side of Dark", "Title": "Something"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+	idx.SetScoreThreshold(0)
+
+	r, err := idx.Search("dark side", 10)
+	require.Nil(t, err)
+	assert.Len(t, r, 2)
+
+	r, err = idx.SearchPhrase("dark side", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+
+	result, err := idx.SearchWith("dark side", index.SearchOptions{Size: 10, Phrase: true})
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, result.Positions)
+
+	result, err = idx.SearchWith("drak", index.SearchOptions{Size: 10, Fuzziness: 2})
+	require.Nil(t, err)
+	assert.Len(t, result.Positions, 2)
+
+	_, err = idx.SearchWith("dark side", index.SearchOptions{Size: 10, Phrase: true, Fuzziness: 1})
+	assert.NotNil(t, err)
+}
+
+func TestSearchTotal(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, total, err := idx.SearchTotal("beatles", 1)
+	require.Nil(t, err)
+	assert.Len(t, r, 1)
+	assert.Equal(t, uint64(2), total)
+}
+
+func TestSearchPath(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "music/live_bootlegs/1999-tour/01-intro.flac", "Title": "Intro"}),
+		newSong(mpd.Attrs{"file": "music/studio/02-outro.flac", "Title": "Outro"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+	idx.SetScoreThreshold(0)
+
+	r, err := idx.Search("Path:bootlegs", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+
+	r, err = idx.Search("Path:tour", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+
+	// Paths do not take part in unqualified searches.
+	r, err = idx.Search("bootlegs", 10)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+
+	r, err = idx.SearchField("path", "live_bootlegs", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+}
+
+func TestSearchWithin(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.SearchWithin("beatles", []int{1, 2}, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1}, r)
+
+	r, err = idx.SearchWithin("beatles", []int{0, 1, 2}, 10)
+	require.Nil(t, err)
+	all, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, all, r)
+
+	r, err = idx.SearchWithin("beatles", []int{}, 10)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+}
+
+func TestSetScoreThreshold(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Artist": "The Beatles", "Title": "Yesterday"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Artist": "Someone", "Title": "Other", "Genre": "Songs of yesterday and today and tomorrow"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+	assert.Equal(t, index.SEARCH_SCORE_THRESHOLD, idx.ScoreThreshold())
+
+	// With the default field boosts, a match in the title clears the
+	// default threshold, while a match in a long genre tag does not.
+	r, err := idx.Search("yesterday", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+
+	idx.SetScoreThreshold(0)
+	r, err = idx.Search("yesterday", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	idx.SetScoreThreshold(100)
+	r, err = idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+}
+
+func TestIsolate(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.Isolate(testSongs[:1], []string{"artist"})
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	// Songs matching any of the selected songs are returned in library order.
+	r, err = idx.Isolate([]*song.Song{testSongs[2], testSongs[0]}, []string{"artist"})
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 2}, r)
+
+	// All tags must match.
+	r, err = idx.Isolate(testSongs[:1], []string{"artist", "title"})
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+
+	// Empty tags are ignored.
+	r, err = idx.Isolate(testSongs[1:2], []string{"artist", "album"})
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	empty := newSong(mpd.Attrs{"file": "x.flac"})
+	r, err = idx.Isolate([]*song.Song{empty}, []string{"artist", "album"})
+	require.Nil(t, err)
+	assert.Empty(t, r)
+
+	_, err = idx.Isolate(testSongs, []string{"nonexistent"})
+	assert.NotNil(t, err)
+}
+
+func TestQueryBuilder(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "0.flac", "artist": "The Beatles", "album": "Help!", "title": "Help"}),
+		newSong(mpd.Attrs{"file": "1.flac", "artist": "The Beatles", "album": "Help!", "title": "Yesterday"}),
+		newSong(mpd.Attrs{"file": "2.flac", "artist": "The Beatles", "album": "Let It Be", "title": "Let It Be"}),
+		newSong(mpd.Attrs{"file": "3.flac", "artist": "Guns N' Roses", "album": "Use Your Illusion I", "title": "Yesterdays"}),
+		newSong(mpd.Attrs{"file": "4.flac", "artist": "Paul McCartney", "album": "Live", "title": "Yesterday Live"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	search := func(b *index.QueryBuilder) []int {
+		r, err := idx.SearchQuery(b, 10)
+		require.Nil(t, err)
+		sort.Ints(r)
+		return r
+	}
+
+	// Must clauses are all required.
+	assert.Equal(t, []int{1}, search(index.NewQueryBuilder().Must(
+		index.Field("artist", "beatles"),
+		index.Term("yesterday"),
+	)))
+
+	// Should clauses alone require at least one match.
+	assert.Equal(t, []int{2, 3}, search(index.NewQueryBuilder().Should(
+		index.Exact("album", "let it be"),
+		index.Field("artist", "roses"),
+	)))
+
+	// Not clauses exclude songs, also on their own.
+	assert.Equal(t, []int{0, 1, 2}, search(index.NewQueryBuilder().Must(
+		index.Field("artist", "beatles"),
+	)))
+	assert.Equal(t, []int{2, 3, 4}, search(index.NewQueryBuilder().Not(
+		index.Exact("album", "help!"),
+	)))
+
+	// Phrases require the words in order.
+	assert.Equal(t, []int{4}, search(index.NewQueryBuilder().Must(
+		index.Phrase("title", "yesterday live"),
+	)))
+	assert.Equal(t, []int{}, search(index.NewQueryBuilder().Must(
+		index.Phrase("", "live yesterday"),
+	)))
+
+	// Exact matches only entire tag values.
+	assert.Equal(t, []int{}, search(index.NewQueryBuilder().Must(
+		index.Exact("album", "use your illusion"),
+	)))
+
+	// Groups nest queries.
+	assert.Equal(t, []int{0, 3}, search(index.NewQueryBuilder().Should(
+		index.Group(index.NewQueryBuilder().Must(
+			index.Field("artist", "beatles"),
+			index.Field("title", "help"),
+		)),
+		index.Group(index.NewQueryBuilder().Must(
+			index.Exact("title", "yesterdays"),
+		).Not(
+			index.Field("artist", "beatles"),
+		)),
+	)))
+
+	// Empty queries and unknown tags are errors.
+	_, err := idx.SearchQuery(index.NewQueryBuilder(), 10)
+	assert.NotNil(t, err)
+	_, err = idx.SearchQuery(index.NewQueryBuilder().Must(index.Field("nonexistent", "foo")), 10)
+	assert.NotNil(t, err)
+	_, err = idx.SearchQuery(index.NewQueryBuilder().Must(index.Group(index.NewQueryBuilder())), 10)
+	assert.NotNil(t, err)
+}
+
+func TestIndexFullContext(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := idx.IndexFullContext(ctx, testSongs[:1])
+	assert.Equal(t, context.Canceled, err)
+
+	// The old index is kept.
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	require.Nil(t, idx.IndexFullContext(context.Background(), testSongs))
+}
+
+func TestSearchField(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.SearchField("artist", "beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	r, err = idx.SearchField("title", "beatles", 10)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+
+	_, err = idx.SearchField("nonexistent", "beatles", 10)
+	assert.NotNil(t, err)
+}
+
+func TestSearchNumericRange(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Track": "5/12", "Date": "1994"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Track": "12", "Date": "1999-02-01"}),
+		newSong(mpd.Attrs{"file": "c.flac", "Date": "2003"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.SearchNumericRange("year", 1990, 1999, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	r, err = idx.SearchNumericRange("track", 0, 5, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+
+	// The first matches in library order are returned, not the top hits.
+	r, err = idx.SearchNumericRange("year", 1990, 2010, 2)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	value, err := idx.FieldValue(1, "track_number")
+	require.Nil(t, err)
+	assert.Equal(t, "12", value)
+
+	_, err = idx.SearchNumericRange("title", 0, 5, 10)
+	assert.NotNil(t, err)
+}
+
+func TestFilterExact(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Album": "OK Computer"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Album": "ok computer"}),
+		newSong(mpd.Attrs{"file": "c.flac", "Album": "OK Computer OKNOTOK 1997 2017"}),
+		newSong(mpd.Attrs{"file": "d.flac", "Album": "Computer OK"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.FilterExact("album", "Ok Computer")
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	_, err = idx.FilterExact("nonexistent", "Ok Computer")
+	assert.NotNil(t, err)
+}
+
+func TestSearchNumericComparisons(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Artist": "Blur", "Date": "1994", "Time": "250"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Artist": "Blur", "Date": "1999", "Time": "400"}),
+		newSong(mpd.Attrs{"file": "c.flac", "Artist": "Blur", "Date": "2003", "Time": "200"}),
+		newSong(mpd.Attrs{"file": "d.flac", "Artist": "Oasis", "Date": "1995", "Time": "280"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.Search("year:>=1990 year:<2000", 10)
+	require.Nil(t, err)
+	sort.Ints(r)
+	assert.Equal(t, []int{0, 1, 3}, r)
+
+	r, err = idx.Search("blur year:>=1990 year:<2000 time:<300", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+
+	// Comparisons do not change the relevance of the remaining query.
+	scored, err := idx.SearchScored("blur", 10)
+	require.Nil(t, err)
+	filtered, err := idx.SearchScored("blur time:<=250", 10)
+	require.Nil(t, err)
+	require.Len(t, filtered, 2)
+	for _, hit := range filtered {
+		for _, other := range scored {
+			if other.Pos == hit.Pos {
+				assert.InDelta(t, other.Score, hit.Score, 1e-9)
+			}
+		}
+	}
+}
+
+func TestSearchDuration(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Time": "1234"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Time": "15"}),
+		newSong(mpd.Attrs{"file": "c.flac", "Time": "0"}),
+		newSong(mpd.Attrs{"file": "d.flac"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.SearchNumericRange("Time", 600, math.Inf(1), 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+
+	r, err = idx.SearchNumericRange("time", 0, 30, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1}, r)
+}
+
+func TestSearchDateRange(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Last-Modified": "2017-03-01T12:00:00Z"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Last-Modified": "2018-06-15T08:30:00Z"}),
+		newSong(mpd.Attrs{"file": "c.flac", "Last-Modified": "yesterday"}),
+		newSong(mpd.Attrs{"file": "d.flac"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, err := idx.SearchDateRange("modified", start, end, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1}, r)
+
+	r, err = idx.SearchDateRange("modified", time.Time{}, end, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	value, err := idx.FieldValue(0, "modified")
+	require.Nil(t, err)
+	assert.Equal(t, "2017-03-01T12:00:00Z", value)
+
+	_, err = idx.SearchDateRange("title", start, end, 10)
+	assert.NotNil(t, err)
+}
+
+func TestSearchDateRangeSize(t *testing.T) {
+	songs := make([]*song.Song, 20)
+	for n := range songs {
+		songs[n] = newSong(mpd.Attrs{
+			"file":          fmt.Sprintf("%d.flac", n),
+			"Last-Modified": fmt.Sprintf("2018-06-%02dT08:30:00Z", n+1),
+		})
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	// The first matches in library order are returned, not the top hits.
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, err := idx.SearchDateRange("modified", start, end, 5)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, r)
+}
+
+func TestSchemaMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	idx, err := index.New(dir)
+	require.Nil(t, err)
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	require.Nil(t, idx.SetVersion(42))
+	idx.Close()
+
+	// An index with the current schema is kept.
+	idx, err = index.New(dir)
+	require.Nil(t, err)
+	assert.Equal(t, 42, idx.Version())
+	idx.Close()
+
+	// An outdated index is recreated, and must be reindexed.
+	require.Nil(t, ioutil.WriteFile(path.Join(dir, "schema"), []byte("0\n"), 0600))
+	idx, err = index.New(dir)
+	require.Nil(t, err)
+	defer idx.Close()
+	assert.Equal(t, 0, idx.Version())
+	_, err = idx.FieldValue(0, "title")
+	assert.Equal(t, index.ErrNotFound, err)
+}
+
+func TestAnalyzer(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+	idx.SetScoreThreshold(0)
+	r, err := idx.Search("the", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	// The standard analyzer ignores stop words, and does not match prefixes.
+	idx, cleanup = newTestIndex(t, testSongs, index.Analyzer(index.ANALYZER_STANDARD))
+	defer cleanup()
+	idx.SetScoreThreshold(0)
+	r, err = idx.Search("the", 10)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+	r, err = idx.Search("beat", 10)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+	r, err = idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Len(t, r, 2)
+
+	_, err = index.NewInMemory(index.Analyzer("nonexistent"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, index.ValidAnalyzer(index.ANALYZER_SONG))
+	assert.Nil(t, index.ValidAnalyzer(index.ANALYZER_GERMAN))
+	assert.NotNil(t, index.ValidAnalyzer("nonexistent"))
+}
+
+func TestLanguageAnalyzer(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Artist": "Motörhead", "Title": "Love Me Like a Reptile"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Artist": "Queen", "Title": "Bohemian Rhapsody"}),
+	}
+
+	// Diacritics are ignored by default.
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+	r, err := idx.Search("motorhead", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+
+	idx, cleanup = newTestIndex(t, songs, index.Analyzer(index.ANALYZER_ENGLISH))
+	defer cleanup()
+	idx.SetScoreThreshold(0)
+	for _, q := range []string{"motorhead", "loving", "reptiles"} {
+		r, err = idx.Search(q, 10)
+		require.Nil(t, err)
+		assert.Equal(t, []int{0}, r, q)
+	}
+}
+
+func TestAnalyzerMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	idx, err := index.New(dir)
+	require.Nil(t, err)
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	require.Nil(t, idx.SetVersion(42))
+	idx.Close()
+
+	// Changing the analyzer recreates the index.
+	idx, err = index.New(dir, index.Analyzer(index.ANALYZER_KEYWORD))
+	require.Nil(t, err)
+	defer idx.Close()
+	assert.Equal(t, 0, idx.Version())
+	_, err = idx.FieldValue(0, "title")
+	assert.Equal(t, index.ErrNotFound, err)
+}
+
+func TestPathWithBase(t *testing.T) {
+	assert.Equal(t, "/var/cache/pms/localhost/6600", index.PathWithBase("/var/cache/pms", "localhost", "6600"))
+	assert.Equal(t, "/var/cache/pms/localhost/6600", index.PathWithBase("/var/cache/pms/", "localhost", "6600"))
+}
+
+func TestSetVersionAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	idx, err := index.New(dir)
+	require.Nil(t, err)
+	require.Nil(t, idx.SetVersion(7))
+	idx.Close()
+
+	data, err := ioutil.ReadFile(path.Join(dir, "state"))
+	require.Nil(t, err)
+	assert.Equal(t, "7\n", string(data))
+
+	// No temporary files are left behind.
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	for _, file := range files {
+		assert.NotEqual(t, '.', file.Name()[0], file.Name())
+	}
+}
+
+func TestReadVersionTolerant(t *testing.T) {
+	states := []string{
+		"\n7\n",
+		"  7 \t\n",
+		"\ufeff7\n",
+		"\n\ufeff 7\r\n\nlocalhost:6600\n",
+	}
+
+	for _, state := range states {
+		dir, err := ioutil.TempDir("", "pms-index-test")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		idx, err := index.New(dir)
+		require.Nil(t, err)
+		idx.Close()
+
+		require.Nil(t, ioutil.WriteFile(path.Join(dir, "state"), []byte(state), 0600))
+
+		idx, err = index.New(dir, index.Server("localhost", "6600"))
+		require.Nil(t, err)
+		assert.Equal(t, 7, idx.Version(), "%q", state)
+		idx.Close()
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	idx, err := index.New(dir, index.Server("localhost", "6600"))
+	require.Nil(t, err)
+	require.Nil(t, idx.SetVersion(42))
+	idx.Close()
+
+	data, err := ioutil.ReadFile(path.Join(dir, "state"))
+	require.Nil(t, err)
+	assert.Equal(t, "42\nlocalhost:6600\n", string(data))
+
+	// The same server keeps the library version.
+	idx, err = index.New(dir, index.Server("localhost", "6600"))
+	require.Nil(t, err)
+	assert.Equal(t, 42, idx.Version())
+	idx.Close()
+
+	// Another server resets it.
+	idx, err = index.New(dir, index.Server("example.com", "6600"))
+	require.Nil(t, err)
+	assert.Equal(t, 0, idx.Version())
+	idx.Close()
+
+	data, err = ioutil.ReadFile(path.Join(dir, "state"))
+	require.Nil(t, err)
+	assert.Equal(t, "0\nexample.com:6600\n", string(data))
+}
+
+func TestLastIndexed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	idx, err := index.New(dir)
+	require.Nil(t, err)
+	_, ok := idx.LastIndexed()
+	assert.False(t, ok)
+
+	before := time.Now().Add(-time.Second)
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	indexed, ok := idx.LastIndexed()
+	assert.True(t, ok)
+	assert.True(t, indexed.After(before))
+	idx.Close()
+
+	// The time is kept when the index is reopened.
+	idx, err = index.New(dir)
+	require.Nil(t, err)
+	defer idx.Close()
+	reopened, ok := idx.LastIndexed()
+	assert.True(t, ok)
+	assert.Equal(t, indexed.Unix(), reopened.Unix())
+}
+
+func TestExportImport(t *testing.T) {
+	src, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+	require.Nil(t, src.SetVersion(42))
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, src.Export(buf))
+
+	dst, cleanup := newTestIndex(t, nil)
+	defer cleanup()
+	require.Nil(t, dst.Import(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, 42, dst.Version())
+
+	r, err := dst.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+	value, err := dst.FieldValue(2, "title")
+	require.Nil(t, err)
+	assert.Equal(t, "Yesterdays", value)
+
+	// Imported songs are not reported as changed.
+	added, removed, changed, err := dst.Diff(testSongs)
+	require.Nil(t, err)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+	assert.Empty(t, changed)
+
+	// Archives from another schema version are rejected.
+	err = dst.Import(strings.NewReader(`{"Format": 1, "Schema": 1, "Version": 1}`))
+	assert.NotNil(t, err)
+	assert.Equal(t, 42, dst.Version())
+}
+
+func TestHealthCheck(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+	assert.Nil(t, idx.HealthCheck())
+
+	require.Nil(t, idx.Close())
+	assert.Equal(t, index.ErrIndexClosed, idx.HealthCheck())
+}
+
+func TestStats(t *testing.T) {
+	idx, cleanup := newDiskIndex(t, testSongs)
+	defer cleanup()
+	require.Nil(t, idx.SetVersion(3))
+
+	count, err := idx.DocCount()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(len(testSongs)), count)
+
+	stats := idx.Stats()
+	assert.Equal(t, uint64(len(testSongs)), stats.Documents)
+	assert.Equal(t, index.INDEX_SCHEMA_VERSION, stats.Schema)
+	assert.Equal(t, 3, stats.Version)
+	assert.NotEmpty(t, stats.Path)
+	assert.True(t, stats.Size > 0)
+}
+
+func TestSearchFuzzy(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.SearchFuzzy("beetles", 1, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	r, err = idx.SearchFuzzy("beetles", 0, 10)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+
+	// Fuzziness is clamped to what Bleve supports.
+	r, err = idx.SearchFuzzy("beetlez", 5, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	// The score threshold is relative to the best match.
+	idx.SetScoreThreshold(100)
+	r, err = idx.SearchFuzzy("beetles", 1, 10)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+	result, err := idx.SearchWith("beetles", index.SearchOptions{Size: 10, Fuzziness: 1})
+	require.Nil(t, err)
+	assert.Empty(t, result.Positions)
+	idx.SetScoreThreshold(1)
+	r, err = idx.SearchFuzzy("beetles", 1, 10)
+	require.Nil(t, err)
+	assert.Len(t, r, 1)
+}
+
+func TestSearchPrefix(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.SearchPrefix("artist", "The Bea", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	r, err = idx.SearchPrefix("title", "yesterday", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, r)
+
+	r, err = idx.SearchWildcard("title", "YESTERDAY?", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{2}, r)
+
+	_, err = idx.SearchPrefix("nonexistent", "a", 10)
+	assert.NotNil(t, err)
+}
+
+func TestSearchPaged(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, total, err := idx.SearchPaged("beatles", 0, 1)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0}, r)
+	assert.Equal(t, 2, total)
+
+	r, total, err = idx.SearchPaged("beatles", 1, 1)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1}, r)
+	assert.Equal(t, 2, total)
+
+	r, _, err = idx.SearchPaged("beatles", 2, 1)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+}
+
+func TestQueryThresholdWithSort(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	// The hits of this query score below the threshold.
+	request := bleve.NewSearchRequest(bleve.NewQueryStringQuery("yesterday"))
+	r, _, err := idx.Query(request)
+	require.Nil(t, err)
+	assert.Empty(t, r)
+
+	// When hits are not ordered by score, no hits are discarded.
+	request.SortBy([]string{"-_id"})
+	r, _, err = idx.Query(request)
+	require.Nil(t, err)
+	assert.Equal(t, []int{2, 1}, r)
+}
+
+func TestSearchSorted(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Artist": "Foo", "Album": "B", "Track": "10"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Artist": "Foo", "Album": "B", "Track": "9"}),
+		newSong(mpd.Attrs{"file": "c.flac", "Artist": "Foo", "Album": "A", "Track": "2"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+
+	r, err := idx.SearchSorted("foo", []string{"album", "track"}, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{2, 1, 0}, r)
+
+	r, err = idx.SearchSorted("foo", []string{"-track"}, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 2}, r)
+
+	r, err = idx.SearchSorted("foo", []string{"-_id"}, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{2, 1, 0}, r)
+
+	_, err = idx.SearchSorted("foo", []string{"nonexistent"}, 10)
+	assert.NotNil(t, err)
+}
+
+func TestSearchHighlighted(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	r, err := idx.SearchHighlighted("beatles", 10)
+	require.Nil(t, err)
+	require.Len(t, r, 2)
+	assert.Equal(t, 0, r[0].Pos)
+	require.NotEmpty(t, r[0].Fragments["artist"])
+	assert.Contains(t, r[0].Fragments["artist"][0], "<mark>")
+}
+
+func TestSearchAfterClose(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	require.Nil(t, idx.Close())
+	assert.Nil(t, idx.Close())
+
+	_, err := idx.Search("beatles", 10)
+	assert.Equal(t, index.ErrIndexClosed, err)
+
+	_, err = idx.FieldValue(0, "title")
+	assert.Equal(t, index.ErrIndexClosed, err)
+
+	err = idx.IndexFull(testSongs, make(chan int))
+	assert.Equal(t, index.ErrIndexClosed, err)
+}
+
+func TestSetBatchSize(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+
+	assert.Equal(t, index.INDEX_BATCH_SIZE, idx.BatchSize())
+	assert.NotNil(t, idx.SetBatchSize(0))
+	require.Nil(t, idx.SetBatchSize(2))
+	assert.Equal(t, 2, idx.BatchSize())
+
+	batches := 0
+	idx.OnBatchCommitted(func(done, total int) {
+		batches++
+	})
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	assert.True(t, batches > 1)
+
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+}
+
+func TestIndexFullBatchBoundaries(t *testing.T) {
+	const batchSize = 2
+
+	for _, count := range []int{0, batchSize, batchSize + 1} {
+		songs := make([]*song.Song, count)
+		for n := range songs {
+			songs[n] = newSong(mpd.Attrs{"file": fmt.Sprintf("%d.flac", n), "Artist": "Beatles"})
+		}
+
+		idx, cleanup := newTestIndex(t, nil)
+		require.Nil(t, idx.SetBatchSize(batchSize))
+
+		commits := make([]int, 0)
+		idx.OnBatchCommitted(func(done, total int) {
+			assert.Equal(t, count, total)
+			commits = append(commits, done)
+		})
+		require.Nil(t, idx.IndexFull(songs, make(chan int)))
+
+		docs, err := idx.DocCount()
+		require.Nil(t, err)
+		assert.Equal(t, uint64(count), docs)
+
+		switch count {
+		case 0:
+			assert.Empty(t, commits)
+		case batchSize:
+			assert.Equal(t, []int{batchSize}, commits)
+		case batchSize + 1:
+			assert.Equal(t, []int{batchSize, batchSize + 1}, commits)
+		}
+
+		cleanup()
+	}
+}
+
+func TestIndexFullProgress(t *testing.T) {
+	idx, cleanup := newTestIndex(t, nil)
+	defer cleanup()
+	require.Nil(t, idx.SetBatchSize(2))
+
+	progress := make([]int, 0)
+	err := idx.IndexFullProgress(testSongs, func(done, total int) {
+		assert.Equal(t, len(testSongs), total)
+		progress = append(progress, done)
+	})
+	require.Nil(t, err)
+	assert.Equal(t, []int{2, 3}, progress)
+
+	require.Nil(t, idx.IndexFullProgress(testSongs, nil))
+}
+
+// numberedSongs returns count songs with distinct titles.
+func numberedSongs(count int) []*song.Song {
+	songs := make([]*song.Song, count)
+	for n := range songs {
+		songs[n] = newSong(mpd.Attrs{
+			"file":   fmt.Sprintf("%d.flac", n),
+			"Artist": fmt.Sprintf("Artist %d", n%10),
+			"Title":  fmt.Sprintf("Song number %d", n),
+		})
+	}
+	return songs
+}
+
+func TestIndexWorkers(t *testing.T) {
+	idx, cleanup := newTestIndex(t, nil)
+	defer cleanup()
+	assert.Equal(t, 1, idx.IndexWorkers())
+	assert.NotNil(t, idx.SetIndexWorkers(0))
+
+	require.Nil(t, idx.SetBatchSize(3))
+	require.Nil(t, idx.SetIndexWorkers(4))
+
+	songs := numberedSongs(50)
+	last := 0
+	err := idx.IndexFullProgress(songs, func(done, total int) {
+		assert.True(t, done > last)
+		last = done
+	})
+	require.Nil(t, err)
+	assert.Equal(t, len(songs), last)
+
+	// Songs are indexed under their positions.
+	for pos, s := range songs {
+		value, err := idx.FieldValue(pos, "title")
+		require.Nil(t, err)
+		assert.Equal(t, s.StringTags["title"], value)
+	}
+}
+
+// recordingMetrics records the measurements received from an index.
+type recordingMetrics struct {
+	batches []int
+	hits    []int
+}
+
+func (m *recordingMetrics) BatchCommitted(count int, took time.Duration) {
+	m.batches = append(m.batches, count)
+}
+
+func (m *recordingMetrics) QueryExecuted(hits int, total uint64, took time.Duration) {
+	m.hits = append(m.hits, hits)
+}
+
+func TestMetrics(t *testing.T) {
+	idx, cleanup := newTestIndex(t, nil)
+	defer cleanup()
+	require.Nil(t, idx.SetBatchSize(2))
+
+	m := &recordingMetrics{}
+	idx.SetMetrics(m)
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	_, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{2, 1}, m.batches)
+	assert.Equal(t, []int{2}, m.hits)
+
+	idx.SetMetrics(nil)
+	_, err = idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Len(t, m.hits, 1)
+}
+
+func TestMaxResults(t *testing.T) {
+	idx, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+	assert.Equal(t, 0, idx.MaxResults())
+
+	r, err := idx.Search("beatles", 0)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	request := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	request.Size = 0
+	r, _, err = idx.Query(request)
+	require.Nil(t, err)
+	assert.Len(t, r, 3)
+
+	idx.SetMaxResults(1)
+	assert.Equal(t, 1, idx.MaxResults())
+	r, err = idx.Search("beatles", 1000000)
+	require.Nil(t, err)
+	assert.Len(t, r, 1)
+
+	// The caller's request is not modified.
+	request.Size = 1000000
+	r, _, err = idx.Query(request)
+	require.Nil(t, err)
+	assert.Len(t, r, 1)
+	assert.Equal(t, 1000000, request.Size)
+
+	// Internal scans see every song.
+	added, removed, changed, err := idx.Diff(testSongs[:1])
+	require.Nil(t, err)
+	assert.Empty(t, added)
+	assert.Equal(t, []int{1, 2}, removed)
+	assert.Empty(t, changed)
+
+	r, err = idx.PositionsForField("artist", "the beatles")
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	r, err = idx.Isolate(testSongs[:1], []string{"artist"})
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	buf := &bytes.Buffer{}
+	require.Nil(t, idx.Export(buf))
+	assert.Equal(t, 1+len(testSongs), strings.Count(buf.String(), "\n"))
+}
+
+func TestReindex(t *testing.T) {
+	idx, cleanup := newTestIndex(t, nil)
+	defer cleanup()
+
+	indexed, err := idx.Reindex(5, testSongs)
+	require.Nil(t, err)
+	assert.True(t, indexed)
+	assert.Equal(t, 5, idx.Version())
+
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	// Versions up to the indexed version are ignored.
+	for _, version := range []int{4, 5} {
+		indexed, err = idx.Reindex(version, nil)
+		require.Nil(t, err)
+		assert.False(t, indexed)
+	}
+	r, err = idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+}
+
+func TestStartAutoReindex(t *testing.T) {
+	idx, cleanup := newTestIndex(t, nil)
+	defer cleanup()
+
+	fetches := make(chan struct{}, 10)
+	fetch := func() ([]*song.Song, error) {
+		fetches <- struct{}{}
+		return testSongs, nil
+	}
+
+	// waitForVersion fails the test if the index does not reach the given
+	// version in time.
+	waitForVersion := func(version int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for idx.Version() != version {
+			if time.Now().After(deadline) {
+				t.Fatalf("Index version is %d, expected %d", idx.Version(), version)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	versions := make(chan int)
+	done := idx.StartAutoReindex(ctx, versions, fetch)
+
+	versions <- 5
+	waitForVersion(5)
+	assert.Len(t, fetches, 1)
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	// The indexed version is ignored.
+	versions <- 5
+	versions <- 6
+	waitForVersion(6)
+	assert.Len(t, fetches, 2)
+
+	// Cancelling the context stops the job.
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Automatic reindex job did not stop after cancelling the context")
+	}
+	select {
+	case versions <- 7:
+		t.Fatalf("Automatic reindex job received a version after stopping")
+	default:
+	}
+	assert.Len(t, fetches, 2)
+	assert.Equal(t, 6, idx.Version())
+}
+
+func TestClear(t *testing.T) {
+	idx, cleanup := newDiskIndex(t, testSongs)
+	defer cleanup()
+	require.Nil(t, idx.SetBatchSize(2))
+	require.Nil(t, idx.SetVersion(5))
+
+	n, err := idx.Clear()
+	require.Nil(t, err)
+	assert.Equal(t, len(testSongs), n)
+	assert.Equal(t, 0, idx.Version())
+
+	count, err := idx.DocCount()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(0), count)
+
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Len(t, r, 0)
+
+	// The cleared index can be rebuilt in place.
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	r, err = idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+}
+
+func TestIndexFullAsync(t *testing.T) {
+	idx, cleanup := newTestIndex(t, nil)
+	defer cleanup()
+	require.Nil(t, idx.SetBatchSize(1))
+
+	events := make([]index.IndexProgress, 0)
+	for event := range idx.IndexFullAsync(context.Background(), testSongs) {
+		events = append(events, event)
+	}
+	require.NotEmpty(t, events)
+	last := events[len(events)-1]
+	assert.True(t, last.Finished)
+	assert.Nil(t, last.Err)
+	assert.Equal(t, len(testSongs), last.Done)
+	assert.Equal(t, len(testSongs), last.Total)
+	for _, event := range events[:len(events)-1] {
+		assert.False(t, event.Finished)
+	}
+
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	// Cancelling the context aborts indexing, and keeps the old index.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for event := range idx.IndexFullAsync(ctx, testSongs[2:]) {
+		last = event
+	}
+	assert.True(t, last.Finished)
+	assert.Equal(t, context.Canceled, last.Err)
+
+	r, err = idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+}
+
+func TestPurge(t *testing.T) {
+	idx, cleanup := newDiskIndex(t, testSongs)
+	defer cleanup()
+	require.Nil(t, idx.SetVersion(5))
+
+	require.Nil(t, idx.Purge())
+	assert.Equal(t, 0, idx.Version())
+	_, ok := idx.LastIndexed()
+	assert.False(t, ok)
+
+	stats := idx.Stats()
+	assert.Equal(t, uint64(0), stats.Documents)
+	assert.Equal(t, index.INDEX_SCHEMA_VERSION, stats.Schema)
+
+	// The purged index can be rebuilt in place.
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	idx.Close()
+	assert.Equal(t, index.ErrIndexClosed, idx.Purge())
+}
+
+func TestPruneCache(t *testing.T) {
+	base, err := ioutil.TempDir("", "pms-cache-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(base)
+
+	for _, port := range []string{"6600", "6601"} {
+		idx, err := index.New(index.PathWithBase(base, "localhost", port))
+		require.Nil(t, err)
+		require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+		require.Nil(t, idx.Close())
+	}
+
+	// Make the first index look stale.
+	stale := index.PathWithBase(base, "localhost", "6600")
+	old := time.Now().Add(-100 * 24 * time.Hour)
+	files, err := ioutil.ReadDir(stale)
+	require.Nil(t, err)
+	for _, file := range files {
+		require.Nil(t, os.Chtimes(path.Join(stale, file.Name()), old, old))
+	}
+	require.Nil(t, os.Chtimes(stale, old, old))
+
+	cache, err := index.ListCache(base)
+	require.Nil(t, err)
+	require.Len(t, cache, 2)
+	assert.Equal(t, "6601", cache[0].Port)
+	assert.Equal(t, "6600", cache[1].Port)
+	assert.Equal(t, "localhost", cache[1].Host)
+	assert.True(t, cache[1].Size > 0)
+	assert.True(t, cache[1].LastUsed.Before(cache[0].LastUsed))
+
+	pruned, err := index.PruneCache(base, time.Now().Add(-90*24*time.Hour))
+	require.Nil(t, err)
+	require.Len(t, pruned, 1)
+	assert.Equal(t, stale, pruned[0].Path)
+
+	_, err = os.Stat(stale)
+	assert.True(t, os.IsNotExist(err))
+
+	cache, err = index.ListCache(base)
+	require.Nil(t, err)
+	require.Len(t, cache, 1)
+	assert.Equal(t, "6601", cache[0].Port)
+
+	// A missing cache directory is empty.
+	cache, err = index.ListCache(path.Join(base, "missing"))
+	require.Nil(t, err)
+	assert.Len(t, cache, 0)
+}
+
+func TestIndexStream(t *testing.T) {
+	idx, cleanup := newTestIndex(t, nil)
+	defer cleanup()
+	require.Nil(t, idx.SetBatchSize(2))
+
+	songs := make(chan *song.Song)
+	go func() {
+		for _, s := range testSongs {
+			songs <- s
+		}
+		close(songs)
+	}()
+	require.Nil(t, idx.IndexStream(context.Background(), songs))
+
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	// Cancelling the stream keeps the old index.
+	ctx, cancel := context.WithCancel(context.Background())
+	songs = make(chan *song.Song)
+	go func() {
+		songs <- testSongs[2]
+		cancel()
+	}()
+	assert.Equal(t, context.Canceled, idx.IndexStream(ctx, songs))
+
+	r, err = idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+}
+
+func BenchmarkIndexFull(b *testing.B) {
+	songs := numberedSongs(1000)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			idx, err := index.NewInMemory()
+			require.Nil(b, err)
+			defer idx.Close()
+			require.Nil(b, idx.SetBatchSize(250))
+			require.Nil(b, idx.SetIndexWorkers(workers))
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				require.Nil(b, idx.IndexFull(songs, make(chan int)))
+			}
+		})
+	}
+}
+
+func TestNewInMemory(t *testing.T) {
+	idx, err := index.NewInMemory()
+	require.Nil(t, err)
+	defer idx.Close()
+
+	require.Nil(t, idx.IndexFull(testSongs, make(chan int)))
+	require.Nil(t, idx.SetVersion(12))
+	assert.Equal(t, 12, idx.Version())
+
+	r, err := idx.Search("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, []int{0, 1}, r)
+
+	// Reindexing replaces the previous contents.
+	require.Nil(t, idx.IndexFull(testSongs[2:], make(chan int)))
+	count, err := idx.DocCount()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(1), count)
+}
+
+func TestOptimize(t *testing.T) {
+	idx, cleanup := newDiskIndex(t, testSongs)
+	defer cleanup()
+
+	require.Nil(t, idx.DeleteAt([]int{2}))
+	before, err := idx.SearchScored("beatles", 10)
+	require.Nil(t, err)
+
+	require.Nil(t, idx.Optimize())
+
+	after, err := idx.SearchScored("beatles", 10)
+	require.Nil(t, err)
+	assert.Equal(t, before, after)
+	value, err := idx.FieldValue(0, "title")
+	require.Nil(t, err)
+	assert.Equal(t, "Help", value)
+
+	count, err := idx.DocCount()
+	require.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	mem, cleanup := newTestIndex(t, testSongs)
+	defer cleanup()
+	assert.Nil(t, mem.Optimize())
+}
+
+func TestASCIIFolding(t *testing.T) {
+	songs := []*song.Song{
+		newSong(mpd.Attrs{"file": "a.flac", "Artist": "Björk"}),
+		newSong(mpd.Attrs{"file": "b.flac", "Artist": "Motorhead"}),
+		newSong(mpd.Attrs{"file": "c.flac", "Artist": "Røyksopp"}),
+		newSong(mpd.Attrs{"file": "d.flac", "Artist": "Æther Straße"}),
+	}
+	idx, cleanup := newTestIndex(t, songs)
+	defer cleanup()
+	idx.SetScoreThreshold(0)
+
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{"bjork", 0},
+		{"BJÖRK", 0},
+		{"motörhead", 1},
+		{"royksopp", 2},
+		{"aether", 3},
+		{"strasse", 3},
+	}
+	for _, test := range tests {
+		r, err := idx.Search(test.query, 10)
+		require.Nil(t, err)
+		assert.Equal(t, []int{test.pos}, r, test.query)
+	}
+
+	r, err := idx.PositionsForField("artist", "royksopp")
+	require.Nil(t, err)
+	assert.Equal(t, []int{2}, r)
+}
@@ -0,0 +1,44 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// LastIndexed returns the time the index was last fully rebuilt by IndexFull
+// or one of its variants. False is returned if the index has never been
+// rebuilt.
+func (i *Index) LastIndexed() (time.Time, bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.lastIndexed, !i.lastIndexed.IsZero()
+}
+
+// setLastIndexed records the time of a successful full rebuild in the
+// indexed file.
+func (i *Index) setLastIndexed(t time.Time) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if !i.memOnly {
+		err := writeFileAtomic(i.indexedPath, []byte(t.UTC().Format(time.RFC3339)+"\n"))
+		if err != nil {
+			return err
+		}
+	}
+	i.lastIndexed = t
+	return nil
+}
+
+// readLastIndexed reads the time of the last full rebuild from the indexed
+// file. A zero time is returned if the index has never been rebuilt.
+func (i *Index) readLastIndexed() (time.Time, error) {
+	data, err := ioutil.ReadFile(i.indexedPath)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,72 @@
+package index
+
+import (
+	"sort"
+
+	"github.com/ambientsound/pms/song"
+	"github.com/blevesearch/bleve"
+)
+
+// Isolate takes a list of songs and a set of tag keys, and returns the
+// positions of all songs which share the values of those tags with any of the
+// given songs. For instance, the tags "albumartist" and "album" yield all
+// songs from the same albums as the given songs. Empty tag values are
+// ignored; if all values are empty, the result is empty.
+//
+// All matching songs are returned in library order, regardless of the score
+// threshold.
+func (i *Index) Isolate(songs []*song.Song, tags []string) ([]int, error) {
+	for _, tag := range tags {
+		if _, err := i.indexedField(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create a cartesian join for song values and tag list.
+	b := NewQueryBuilder()
+	for _, s := range songs {
+		group := NewQueryBuilder()
+
+		for _, tag := range tags {
+
+			// Ignore empty values
+			tagValue := s.StringTags[tag]
+			if len(tagValue) == 0 {
+				continue
+			}
+
+			group.Must(Phrase(tag, tagValue))
+		}
+
+		if group.Empty() {
+			continue
+		}
+		b.Should(Group(group))
+	}
+
+	if b.Empty() {
+		return []int{}, nil
+	}
+
+	count, err := i.DocCount()
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := b.Query(i)
+	if err != nil {
+		return nil, err
+	}
+
+	request := bleve.NewSearchRequest(q)
+	request.Size = int(count)
+
+	r, _, err := i.queryAll(request)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Ints(r)
+
+	return r, nil
+}
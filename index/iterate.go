@@ -0,0 +1,83 @@
+package index
+
+import (
+	"github.com/ambientsound/pms/console"
+)
+
+// SEARCH_PAGE_SIZE is the number of results fetched at a time by SearchAll.
+const SEARCH_PAGE_SIZE int = 100
+
+// SearchAll runs a natural language query against the index, and returns an
+// iterator over the positions of all matching songs. Each call to the
+// iterator returns the next position, or false when there are no more
+// results. Results are fetched from the index one page at a time, as the
+// iterator advances. Each position is returned only once, even if an
+// inconsistent index has several documents for it.
+//
+// Errors while fetching the first page are returned by SearchAll. If a later
+// page cannot be fetched, the error is logged and iteration stops.
+func (i *Index) SearchAll(q string) (func() (int, bool), error) {
+	q = normalizeQuery(q)
+	from := 0
+	done := false
+	seen := make(map[int]bool)
+
+	// fetch retrieves the next page of results.
+	fetch := func() ([]int, error) {
+		request := i.searchRequest(q, SEARCH_PAGE_SIZE)
+		request.From = from
+		scored, sr, err := i.queryScored(request, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		from += len(sr.Hits)
+
+		// The page may be smaller than requested, due to SetMaxResults, so
+		// paging is finished only when all of the matching hits are seen.
+		done = len(sr.Hits) == 0 || uint64(from) >= sr.Total
+
+		threshold := i.ScoreThreshold()
+		if !sortedByScore(request) || !scoredByRelevance(request.Query) {
+			threshold = 0
+		}
+
+		r := make([]int, 0, len(scored))
+		for _, hit := range scored {
+			// Results are ordered by score, so once a hit is below the
+			// threshold, no later hit or page can contain a result.
+			if hit.Score < threshold {
+				done = true
+				break
+			}
+			if seen[hit.Pos] {
+				continue
+			}
+			seen[hit.Pos] = true
+			r = append(r, hit.Pos)
+		}
+
+		return r, nil
+	}
+
+	page, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	return func() (int, bool) {
+		for len(page) == 0 {
+			if done {
+				return 0, false
+			}
+			page, err = fetch()
+			if err != nil {
+				console.Log("Error while fetching search results for '%s': %s", q, err)
+				done = true
+				return 0, false
+			}
+		}
+		pos := page[0]
+		page = page[1:]
+		return pos, true
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package index
+
+// isLocked always returns false, as lock detection is not supported on this
+// platform.
+func isLocked(path string) (bool, error) {
+	return false, nil
+}
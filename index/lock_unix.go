@@ -0,0 +1,33 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// isLocked returns true if another process holds a lock on the Bleve store
+// file. The store takes an exclusive lock on the file while it is open, so a
+// shared lock is taken without blocking, and released immediately. Other
+// processes probing the lock at the same time also take shared locks, and do
+// not make the index appear locked.
+func isLocked(path string) (bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return false, syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,53 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package index_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ambientsound/pms/index"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIndexLockProbe checks that a concurrent lock probe, which holds a
+// shared lock on the store for a moment, is not mistaken for another process
+// using the index.
+func TestIndexLockProbe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pms-index-test")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	idx, err := index.New(dir)
+	require.Nil(t, err)
+	require.Nil(t, idx.Close())
+
+	file, err := os.Open(path.Join(dir, "index", "store"))
+	require.Nil(t, err)
+	defer file.Close()
+	require.Nil(t, syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB))
+
+	done := make(chan error)
+	go func() {
+		idx, err := index.New(dir)
+		if err == nil {
+			err = idx.Close()
+		}
+		done <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	require.Nil(t, syscall.Flock(int(file.Fd()), syscall.LOCK_UN))
+
+	select {
+	case err := <-done:
+		require.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out opening the index")
+	}
+}
@@ -1,17 +1,203 @@
 package index
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ambientsound/pms/console"
+	"github.com/ambientsound/pms/index/filters/asciifold"
 	"github.com/ambientsound/pms/index/filters/unicodestrip"
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
 	"github.com/blevesearch/bleve/analysis/token/edgengram"
 	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	regexp_tokenizer "github.com/blevesearch/bleve/analysis/tokenizer/regexp"
+	"github.com/blevesearch/bleve/analysis/tokenizer/single"
 	"github.com/blevesearch/bleve/analysis/tokenizer/whitespace"
 	"github.com/blevesearch/bleve/mapping"
 )
 
+// EXACT_ANALYZER is the name of the analyzer used for exact matching of tag values.
+const EXACT_ANALYZER = "songExactAnalyzer"
+
+// PATH_ANALYZER is the name of the analyzer used for file paths. It splits
+// paths into words at slashes, underscores, dashes, dots and whitespace.
+const PATH_ANALYZER = "songPathAnalyzer"
+
+// mappingOptions controls how song documents are mapped by buildIndexMapping.
+type mappingOptions struct {
+	// stored lists the document fields whose values are stored in the
+	// index, and can be retrieved later. If nil, all fields are stored.
+	stored []string
+
+	// indexed lists the document fields which are indexed, and can be
+	// searched. If nil, all fields are indexed.
+	indexed []string
+
+	// scoring is the scoring model recorded in the index.
+	scoring string
+
+	// analyzer is the analyzer setting used for song tags; see Analyzer.
+	analyzer string
+
+	// file is the path to a JSON file containing a Bleve index mapping. If
+	// the file exists, it is used instead of the built-in mapping.
+	file string
+
+	// customize, if not nil, is called to modify the index mapping before
+	// the index is created.
+	customize func(*mapping.IndexMappingImpl) error
+
+	// passphrase, if not empty, encrypts the index; see Passphrase.
+	passphrase string
+}
+
+// internalFields lists document fields used for bookkeeping by the index
+// itself. They are always stored, and are not searchable as text.
+var internalFields = map[string]bool{
+	"Hash": true,
+}
+
+// fieldAnalyzers maps document fields to the analyzer used for them instead
+// of the default analyzer. These fields are only searched when named in the
+// query, e.g. "path:bootlegs", so that they do not affect the relevance of
+// natural language searches.
+var fieldAnalyzers = map[string]string{
+	"Path": PATH_ANALYZER,
+}
+
+// isStored returns true if the given document field should be stored. The
+// file name and internal fields are always stored, as they are needed to
+// compare indexed songs against the song library.
+func (o mappingOptions) isStored(field string) bool {
+	if field == "File" || internalFields[field] {
+		return true
+	}
+	return o.stored == nil || contains(o.stored, field)
+}
+
+// isIndexed returns true if the given document field should be indexed.
+// Internal fields are always indexed.
+func (o mappingOptions) isIndexed(field string) bool {
+	return o.indexed == nil || internalFields[field] || contains(o.indexed, field)
+}
+
+// contains returns true if the slice contains the given string.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// newIndexMapping returns the index mapping loaded from the configured
+// mapping file, or the built-in mapping if there is no such file. The mapping
+// is then modified by the configured customization function, if any.
+func newIndexMapping(options mappingOptions) (mapping.IndexMapping, error) {
+	m, err := loadOrBuildIndexMapping(options)
+	if err != nil || options.customize == nil {
+		return m, err
+	}
+
+	impl, ok := m.(*mapping.IndexMappingImpl)
+	if !ok {
+		return nil, fmt.Errorf("Search index mapping of type %T cannot be customized", m)
+	}
+
+	err = options.customize(impl)
+	if err != nil {
+		return nil, fmt.Errorf("while customizing search index mapping: %s", err)
+	}
+
+	err = impl.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("customized search index mapping is invalid: %s", err)
+	}
+
+	return impl, nil
+}
+
+// loadOrBuildIndexMapping returns the index mapping loaded from the
+// configured mapping file, or the built-in mapping if there is no such file.
+func loadOrBuildIndexMapping(options mappingOptions) (mapping.IndexMapping, error) {
+	if len(options.file) > 0 {
+		m, err := loadIndexMapping(options.file)
+		if err == nil {
+			console.Log("Using search index mapping from %s", options.file)
+			addInternalFieldMappings(m)
+			return m, nil
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("while loading search index mapping from %s: %s", options.file, err)
+		}
+	}
+
+	m, err := buildIndexMapping(options)
+	if err != nil {
+		return nil, fmt.Errorf("BUG: unable to create search index mapping: %s", err)
+	}
+
+	return m, nil
+}
+
+// loadIndexMapping reads and validates a Bleve index mapping in JSON format.
+func loadIndexMapping(path string) (*mapping.IndexMappingImpl, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := bleve.NewIndexMapping()
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// addInternalFieldMappings forces the mappings that the index relies on onto
+// a mapping loaded from file: internal fields are stored as single keywords
+// and kept out of the catch-all field, and the file name is always stored.
+func addInternalFieldMappings(m *mapping.IndexMappingImpl) {
+	if m.DefaultMapping == nil {
+		m.DefaultMapping = bleve.NewDocumentMapping()
+	}
+	if m.DefaultMapping.Properties == nil {
+		m.DefaultMapping.Properties = make(map[string]*mapping.DocumentMapping)
+	}
+
+	for field := range internalFields {
+		fieldMapping := bleve.NewTextFieldMapping()
+		fieldMapping.Analyzer = keyword.Name
+		fieldMapping.Store = true
+		fieldMapping.IncludeInAll = false
+		fieldMapping.IncludeTermVectors = false
+		delete(m.DefaultMapping.Properties, field)
+		m.DefaultMapping.AddFieldMappingsAt(field, fieldMapping)
+	}
+
+	file, ok := m.DefaultMapping.Properties["File"]
+	if !ok || len(file.Fields) == 0 {
+		m.DefaultMapping.AddFieldMappingsAt("File", bleve.NewTextFieldMapping())
+		return
+	}
+	for _, fieldMapping := range file.Fields {
+		fieldMapping.Store = true
+	}
+}
+
 // buildIndexMapping() returns an object that defines how input data is indexed in Bleve.
-func buildIndexMapping() (mapping.IndexMapping, error) {
+func buildIndexMapping(options mappingOptions) (mapping.IndexMapping, error) {
 	indexMapping := bleve.NewIndexMapping()
 
 	var err error
@@ -34,6 +220,14 @@ func buildIndexMapping() (mapping.IndexMapping, error) {
 		return nil, err
 	}
 
+	err = indexMapping.AddCustomTokenFilter("asciiFolder",
+		map[string]interface{}{
+			"type": asciifold.Name,
+		})
+	if err != nil {
+		return nil, err
+	}
+
 	err = indexMapping.AddCustomAnalyzer("songAnalyzer",
 		map[string]interface{}{
 			"type":         custom.Name,
@@ -41,6 +235,49 @@ func buildIndexMapping() (mapping.IndexMapping, error) {
 			"tokenizer":    whitespace.Name,
 			"token_filters": []interface{}{
 				`unicodeStripper`,
+				`asciiFolder`,
+				lowercase.Name,
+				`songEdgeNgram`,
+			},
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	// The exact analyzer keeps the entire field value as a single,
+	// case-insensitive token, enabling exact matches on tag values.
+	err = indexMapping.AddCustomAnalyzer(EXACT_ANALYZER,
+		map[string]interface{}{
+			"type":         custom.Name,
+			"char_filters": []interface{}{},
+			"tokenizer":    single.Name,
+			"token_filters": []interface{}{
+				`unicodeStripper`,
+				`asciiFolder`,
+				lowercase.Name,
+			},
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = indexMapping.AddCustomTokenizer("songPathTokenizer",
+		map[string]interface{}{
+			"type":   regexp_tokenizer.Name,
+			"regexp": `[^/_\-.\s]+`,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = indexMapping.AddCustomAnalyzer(PATH_ANALYZER,
+		map[string]interface{}{
+			"type":         custom.Name,
+			"char_filters": []interface{}{},
+			"tokenizer":    `songPathTokenizer`,
+			"token_filters": []interface{}{
+				`unicodeStripper`,
+				`asciiFolder`,
 				lowercase.Name,
 				`songEdgeNgram`,
 			},
@@ -49,7 +286,61 @@ func buildIndexMapping() (mapping.IndexMapping, error) {
 		return nil, err
 	}
 
-	indexMapping.DefaultAnalyzer = "songAnalyzer"
+	err = addLanguageAnalyzer(indexMapping, options.analyzer)
+	if err != nil {
+		return nil, err
+	}
+
+	indexMapping.DefaultAnalyzer, err = analyzerName(options.analyzer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Map each field of the song document explicitly, so that storage can
+	// be controlled per field.
+	songMapping := bleve.NewDocumentMapping()
+	for _, field := range documentFields() {
+		var fieldMapping *mapping.FieldMapping
+		switch {
+		case internalFields[field.Name]:
+			fieldMapping = bleve.NewTextFieldMapping()
+			fieldMapping.Analyzer = keyword.Name
+			fieldMapping.IncludeInAll = false
+			fieldMapping.IncludeTermVectors = false
+		case isBoolField(field):
+			fieldMapping = bleve.NewBooleanFieldMapping()
+		case isNumericField(field):
+			fieldMapping = bleve.NewNumericFieldMapping()
+			fieldMapping.IncludeInAll = false
+		case isDateField(field):
+			fieldMapping = bleve.NewDateTimeFieldMapping()
+			fieldMapping.IncludeInAll = false
+		case len(fieldAnalyzers[field.Name]) > 0:
+			fieldMapping = bleve.NewTextFieldMapping()
+			fieldMapping.Analyzer = fieldAnalyzers[field.Name]
+			fieldMapping.IncludeInAll = false
+		default:
+			fieldMapping = bleve.NewTextFieldMapping()
+		}
+		fieldMapping.Store = options.isStored(field.Name)
+		if !options.isIndexed(field.Name) {
+			fieldMapping.Index = false
+			fieldMapping.IncludeInAll = false
+			fieldMapping.IncludeTermVectors = false
+		}
+		songMapping.AddFieldMappingsAt(field.Name, fieldMapping)
+
+		if isTextField(field) && fieldMapping.Index && !internalFields[field.Name] {
+			exactMapping := bleve.NewTextFieldMapping()
+			exactMapping.Name = exactFieldName(field.Name)
+			exactMapping.Analyzer = EXACT_ANALYZER
+			exactMapping.Store = false
+			exactMapping.IncludeInAll = false
+			exactMapping.IncludeTermVectors = false
+			songMapping.AddFieldMappingsAt(field.Name, exactMapping)
+		}
+	}
+	indexMapping.DefaultMapping = songMapping
 
 	return indexMapping, nil
 }
@@ -0,0 +1,49 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/blevesearch/bleve"
+)
+
+// NewInMemory returns an Index which is kept in memory only. Nothing is
+// written to disk: the library version, schema version and query aliases
+// are not persisted, and are lost when the index is closed. This is useful
+// for tests and throwaway indexes.
+func NewInMemory(options ...Option) (*Index, error) {
+	i, err := newIndex(options)
+	if err != nil {
+		return nil, err
+	}
+
+	i.memOnly = true
+	i.bleveIndex, err = createInMemory(i.mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	i.setDefaultFieldBoosts()
+
+	return i, nil
+}
+
+// createInMemory creates a Bleve index which is kept in memory only.
+func createInMemory(options mappingOptions) (bleve.Index, error) {
+	mapping, err := newIndexMapping(options)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := bleve.NewMemOnly(mapping)
+	if err != nil {
+		return nil, fmt.Errorf("while creating in-memory search index: %s", err)
+	}
+
+	err = writeScoring(index, options.scoring)
+	if err != nil {
+		index.Close()
+		return nil, fmt.Errorf("while writing scoring model to in-memory search index: %s", err)
+	}
+
+	return index, nil
+}
@@ -0,0 +1,47 @@
+package index
+
+import (
+	"time"
+)
+
+// Metrics receives measurements of indexing and search performance, e.g. for
+// showing indexing speed in the user interface. Methods may be called from
+// several goroutines at once, and must not call back into the index.
+type Metrics interface {
+	// BatchCommitted is called each time a batch of count songs has been
+	// written to the index, with the time spent building and writing it.
+	BatchCommitted(count int, took time.Duration)
+
+	// QueryExecuted is called after each search, with the number of hits
+	// returned after applying the score threshold, the total number of
+	// matching songs, and the time spent searching.
+	QueryExecuted(hits int, total uint64, took time.Duration)
+}
+
+// SetMetrics registers an observer which receives performance measurements
+// from indexing and searches. Pass nil to remove the observer.
+func (i *Index) SetMetrics(m Metrics) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.metrics = m
+}
+
+// batchMetrics reports a committed batch to the metrics observer, if any.
+func (i *Index) batchMetrics(count int, start time.Time) {
+	i.mutex.RLock()
+	m := i.metrics
+	i.mutex.RUnlock()
+	if m != nil {
+		m.BatchCommitted(count, time.Since(start))
+	}
+}
+
+// queryMetrics reports an executed search to the metrics observer, if any.
+func (i *Index) queryMetrics(hits int, total uint64, took time.Duration) {
+	i.mutex.RLock()
+	m := i.metrics
+	i.mutex.RUnlock()
+	if m != nil {
+		m.QueryExecuted(hits, total, took)
+	}
+}
@@ -0,0 +1,134 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ambientsound/pms/console"
+	"github.com/blevesearch/bleve/index/store/boltdb"
+	"github.com/boltdb/bolt"
+)
+
+// Optimize compacts the index on disk. Incremental updates leave free pages
+// scattered throughout the key/value store; compacting writes all data to a
+// new, tightly packed store file, which is then swapped into place.
+//
+// Optimizing takes about as long as reading and writing the entire index
+// once, and needs free disk space for a second copy of it. The write lock is
+// held throughout, so searches wait until optimization completes. If
+// compacting fails, the index is left as it was. If the index cannot be
+// reopened afterwards, it is closed, and further use returns ErrIndexClosed.
+//
+// In-memory indexes are not optimized.
+func (i *Index) Optimize() error {
+	if i.memOnly {
+		console.Log("In-memory search indexes cannot be optimized.")
+		return nil
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.closed {
+		return ErrIndexClosed
+	}
+
+	// Only Bolt stores, which are the Bleve default, can be compacted.
+	// Encrypted stores keep their encrypted values in a Bolt store.
+	_, kvstore, err := i.bleveIndex.Advanced()
+	if err != nil {
+		return err
+	}
+	if encrypted, ok := kvstore.(*encryptedStore); ok {
+		kvstore = encrypted.KVStore
+	}
+	if _, ok := kvstore.(*boltdb.Store); !ok {
+		console.Log("Search index storage type does not support optimization.")
+		return nil
+	}
+
+	timer := time.Now()
+	store := storePath(i.indexPath)
+	compact := store + ".compact"
+
+	err = i.bleveIndex.Close()
+	if err != nil {
+		return fmt.Errorf("while closing search index: %s", err)
+	}
+
+	err = compactStore(store, compact)
+	if err == nil {
+		err = os.Rename(compact, store)
+	}
+	if err != nil {
+		os.Remove(compact)
+	}
+
+	index, openErr := open(i.indexPath, i.mapping.passphrase)
+	if openErr != nil {
+		i.closed = true
+		return fmt.Errorf("while reopening search index: %s", openErr)
+	}
+	i.bleveIndex = index
+
+	if err != nil {
+		return fmt.Errorf("while optimizing search index: %s", err)
+	}
+
+	console.Log("Optimized search index in %s", time.Since(timer).String())
+
+	return nil
+}
+
+// compactStore copies all buckets of a Bolt database into a new database.
+func compactStore(src, dst string) error {
+	options := &bolt.Options{Timeout: time.Second}
+
+	from, err := bolt.Open(src, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+
+	os.Remove(dst)
+	to, err := bolt.Open(dst, 0600, options)
+	if err != nil {
+		return err
+	}
+
+	err = from.View(func(stx *bolt.Tx) error {
+		return to.Update(func(dtx *bolt.Tx) error {
+			return stx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				bucket, err := dtx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(b, bucket)
+			})
+		})
+	})
+
+	if closeErr := to.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// copyBucket copies all keys, and nested buckets, from one Bolt bucket to another.
+func copyBucket(src, dst *bolt.Bucket) error {
+	// Keys are inserted in order, so pages can be filled completely.
+	dst.FillPercent = 1.0
+
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		bucket, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src.Bucket(k), bucket)
+	})
+}
@@ -0,0 +1,247 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// SEARCH_RESULT_SIZE is the number of results returned by searches when the
+// requested size is zero or less.
+const SEARCH_RESULT_SIZE int = 1000
+
+// SearchOptions controls how SearchWith executes a search. The zero value
+// runs a natural language search, like Search.
+type SearchOptions struct {
+	// Size is the maximum number of results to return. If zero,
+	// SEARCH_RESULT_SIZE results are returned.
+	Size int
+
+	// From is the number of results to skip, for showing results one page
+	// at a time; see SearchPaged.
+	From int
+
+	// Threshold overrides the score threshold of the index; see
+	// SetScoreThreshold. If zero, the index threshold is used. A negative
+	// threshold returns all hits.
+	Threshold float64
+
+	// Sort orders the results by the given tags instead of by relevance;
+	// see SearchSorted.
+	Sort []string
+
+	// Field restricts the search to a single tag, e.g. "artist". The query
+	// is then matched as text; no query syntax is interpreted.
+	Field string
+
+	// Highlight returns the fragments of the song tags that matched the
+	// query; see SearchHighlighted.
+	Highlight bool
+
+	// SubstringFallback enables a second, slower pass when the query
+	// returns no results. The second pass matches the query as a substring
+	// of the artist, album artist, album and title tags.
+	SubstringFallback bool
+
+	// Phrase requires the words of the query to appear in the same tag, in
+	// the same order; see SearchPhrase.
+	Phrase bool
+
+	// Fuzziness is the number of typos tolerated in each word of the query;
+	// see SearchFuzzy. The score threshold is then applied relative to the
+	// best match, as by SearchFuzzy. Fuzzy phrases are not supported.
+	Fuzziness int
+
+	// Facets counts the distinct values of the given tags among the
+	// results, e.g. "artist"; see Facets.
+	Facets []string
+}
+
+// SearchResults is the result of SearchWith. Besides the matching songs, it
+// holds the details requested by the search options.
+type SearchResults struct {
+	SearchResult
+
+	// Total is the number of matching songs, counted before the score
+	// threshold is applied; see SearchTotal.
+	Total uint64
+
+	// Fragments holds the highlighted fragments of each result, in the same
+	// order as Positions; see HighlightedHit. It is only set when
+	// highlighting is requested.
+	Fragments []map[string][]string
+
+	// Spans holds the ranges of each song tag where the query matched, in
+	// the same order as Positions. Unlike Fragments, it covers tags which
+	// are not stored in the index. It is only set when highlighting is
+	// requested.
+	Spans []map[string][]Span
+
+	// Facets holds the distinct values of the tags given in the search
+	// options among the results, one per tag. It is only set when facets
+	// are requested.
+	Facets []FacetResult
+}
+
+// substringFields lists the document fields searched by the substring fallback.
+var substringFields = []string{"Artist", "Albumartist", "Album", "Title"}
+
+// SearchWith runs a natural language query against the index, using the
+// given options. The score threshold is applied in the same way as by the
+// search function implementing the selected mode.
+func (i *Index) SearchWith(q string, opts SearchOptions) (SearchResults, error) {
+	q = normalizeQuery(q)
+	result := SearchResults{SearchResult: SearchResult{Query: q}}
+
+	request, err := i.optionsRequest(q, opts)
+	if err != nil {
+		return result, err
+	}
+
+	facetNames, err := i.indexedFields(opts.Facets)
+	if err != nil {
+		return result, err
+	}
+
+	threshold := opts.Threshold
+	if threshold == 0 {
+		threshold = i.ScoreThreshold()
+	}
+
+	// Fuzzy matches are compared to the best match instead.
+	fuzzy := opts.Fuzziness > 0 && len(opts.Sort) == 0
+	queryThreshold := threshold
+	if fuzzy {
+		queryThreshold = 0
+	}
+
+	scored, sr, err := i.queryScored(request, queryThreshold, nil)
+	if err != nil {
+		return result, err
+	}
+	if fuzzy {
+		scored = fuzzyThreshold(scored, sr.MaxScore, threshold)
+	}
+
+	if len(scored) == 0 && opts.SubstringFallback && opts.From == 0 {
+		request.Query, err = i.substringQuery(q)
+		if err != nil {
+			return result, err
+		}
+		scored, sr, err = i.queryScored(request, threshold, nil)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	result.Total = sr.Total
+	result.Positions = make([]int, len(scored))
+	for n := range scored {
+		result.Positions[n] = scored[n].Pos
+	}
+
+	if opts.Highlight {
+		hits := make(map[int]*search.DocumentMatch, len(sr.Hits))
+		for _, hit := range sr.Hits {
+			if pos, err := hitPosition(hit); err == nil {
+				hits[pos] = hit
+			}
+		}
+		result.Fragments = make([]map[string][]string, len(scored))
+		result.Spans = make([]map[string][]Span, len(scored))
+		for n, pos := range result.Positions {
+			result.Fragments[n] = make(map[string][]string)
+			result.Spans[n] = make(map[string][]Span)
+			hit, ok := hits[pos]
+			if !ok {
+				continue
+			}
+			for field, f := range hit.Fragments {
+				result.Fragments[n][strings.ToLower(field)] = f
+			}
+			result.Spans[n] = hitSpans(hit)
+		}
+	}
+
+	if len(opts.Facets) > 0 {
+		result.Facets, err = i.countFacets(result.Positions, opts.Facets, facetNames)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// optionsRequest returns a search request for a query, built from the given
+// search options.
+func (i *Index) optionsRequest(q string, opts SearchOptions) (*bleve.SearchRequest, error) {
+	field := ""
+	if len(opts.Field) > 0 {
+		name, err := i.indexedField(opts.Field)
+		if err != nil {
+			return nil, err
+		}
+		field = name
+	}
+
+	var request *bleve.SearchRequest
+
+	switch {
+	case opts.Phrase && opts.Fuzziness > 0:
+		return nil, fmt.Errorf("Fuzzy phrase searches are not supported")
+	case opts.Phrase:
+		phrase := bleve.NewMatchPhraseQuery(q)
+		phrase.SetField(field)
+		request = bleve.NewSearchRequest(phrase)
+	case opts.Fuzziness > 0:
+		request = bleve.NewSearchRequest(fuzzyQuery(q, field, opts.Fuzziness))
+	case len(field) > 0:
+		match := bleve.NewMatchQuery(q)
+		match.SetField(field)
+		request = bleve.NewSearchRequest(match)
+	default:
+		request = i.searchRequest(q, opts.Size)
+	}
+
+	request.Size = opts.Size
+	request.From = opts.From
+
+	if len(opts.Sort) > 0 {
+		order, err := i.sortOrder(opts.Sort)
+		if err != nil {
+			return nil, err
+		}
+		request.SortBy(order)
+	}
+
+	if opts.Highlight {
+		request.Highlight = bleve.NewHighlight()
+	}
+
+	return request, nil
+}
+
+// substringQuery returns a query matching songs where any of the substring
+// fields contain the query string.
+func (i *Index) substringQuery(q string) (query.Query, error) {
+	term, err := i.exactTerm(q)
+	if err != nil {
+		return nil, err
+	}
+
+	disjunction := bleve.NewDisjunctionQuery()
+	for _, field := range substringFields {
+		if _, err := i.indexedField(field); err != nil {
+			continue
+		}
+		wildcard := bleve.NewWildcardQuery("*" + term + "*")
+		wildcard.SetField(exactFieldName(field))
+		disjunction.AddQuery(wildcard)
+	}
+
+	return disjunction, nil
+}
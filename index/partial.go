@@ -0,0 +1,101 @@
+package index
+
+import (
+	"fmt"
+	"strconv"
+
+	index_song "github.com/ambientsound/pms/index/song"
+	"github.com/ambientsound/pms/song"
+	"github.com/blevesearch/bleve"
+)
+
+// IndexPartial indexes the songs at the given positions in the song list,
+// replacing any songs previously indexed at those positions. Other songs in
+// the index are not touched. Together with Diff and DeleteAt, this allows
+// updating the index after small library changes without a full reindex.
+// ErrIndexingInProgress is returned while the index is being rebuilt.
+func (i *Index) IndexPartial(songs []*song.Song, positions []int) error {
+	for _, pos := range positions {
+		if pos < 0 || pos >= len(songs) {
+			return fmt.Errorf("Song position %d is out of range", pos)
+		}
+	}
+
+	err := i.batchUpdate(len(positions), func(b *bleve.Batch, n int) error {
+		return indexSong(b, positions[n], songs[positions[n]])
+	})
+	if err == nil {
+		i.checkSize()
+	}
+
+	return err
+}
+
+// DeleteAt removes the songs at the given positions from the index.
+// ErrIndexingInProgress is returned while the index is being rebuilt.
+func (i *Index) DeleteAt(positions []int) error {
+	err := i.batchUpdate(len(positions), func(b *bleve.Batch, n int) error {
+		b.Delete(strconv.Itoa(positions[n]))
+		return nil
+	})
+	if err == nil {
+		i.checkSize()
+	}
+
+	return err
+}
+
+// writable returns an error if songs cannot be updated in place, either
+// because the index is closed, or because it is being rebuilt. The caller
+// must hold the index lock.
+func (i *Index) writable() error {
+	if i.closed {
+		return ErrIndexClosed
+	}
+	if i.indexing {
+		return ErrIndexingInProgress
+	}
+	return nil
+}
+
+// indexSong adds a song to a batch, using its position as document ID.
+func indexSong(b *bleve.Batch, pos int, s *song.Song) error {
+	return b.Index(strconv.Itoa(pos), index_song.New(s))
+}
+
+// batchUpdate calls op count times to build up batches of changes, and
+// applies them to the index, one batch size worth of changes at a time.
+//
+// Changes are rejected with ErrIndexingInProgress while the index is being
+// rebuilt, as they would be lost when the rebuilt index is swapped in.
+func (i *Index) batchUpdate(count int, op func(b *bleve.Batch, n int) error) error {
+	batchSize := i.BatchSize()
+
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	if err := i.writable(); err != nil {
+		return err
+	}
+
+	// Bleve may still be iterating a batch after Batch returns, so each
+	// batch is replaced by a new one instead of being reset.
+	b := i.bleveIndex.NewBatch()
+	for n := 0; n < count; n++ {
+		if err := op(b, n); err != nil {
+			return err
+		}
+		if b.Size() >= batchSize {
+			if err := i.bleveIndex.Batch(b); err != nil {
+				return err
+			}
+			b = i.bleveIndex.NewBatch()
+		}
+	}
+
+	if b.Size() == 0 {
+		return nil
+	}
+
+	return i.bleveIndex.Batch(b)
+}
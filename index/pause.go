@@ -0,0 +1,54 @@
+package index
+
+import (
+	"github.com/ambientsound/pms/console"
+)
+
+// Pause pauses any indexing job in progress, and any indexing job started
+// later. Indexing stops at the next batch boundary, after the current batch
+// has been committed. Searches keep working while indexing is paused.
+func (i *Index) Pause() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.resume == nil {
+		i.resume = make(chan struct{})
+		console.Log("Search indexing paused.")
+	}
+}
+
+// Resume continues indexing after a call to Pause.
+func (i *Index) Resume() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if i.resume != nil {
+		close(i.resume)
+		i.resume = nil
+		console.Log("Search indexing resumed.")
+	}
+}
+
+// Paused returns true if indexing is paused.
+func (i *Index) Paused() bool {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.resume != nil
+}
+
+// waitWhilePaused blocks until indexing is resumed. It returns false if a
+// message is received on the shutdown channel while waiting.
+func (i *Index) waitWhilePaused(shutdown <-chan int) bool {
+	i.mutex.RLock()
+	resume := i.resume
+	i.mutex.RUnlock()
+
+	if resume == nil {
+		return true
+	}
+
+	select {
+	case <-resume:
+		return true
+	case <-shutdown:
+		return false
+	}
+}
@@ -0,0 +1,22 @@
+package index
+
+import (
+	"github.com/blevesearch/bleve"
+)
+
+// SearchPhrase returns the positions of at most size songs where the words
+// of the query appear in the same order in a song tag. Unlike Search, songs
+// matching only some of the words are not returned, and query syntax is not
+// interpreted. Results scoring below the score threshold are discarded.
+func (i *Index) SearchPhrase(q string, size int) ([]int, error) {
+	q = normalizeQuery(q)
+	if len(q) == 0 {
+		return []int{}, nil
+	}
+
+	request := bleve.NewSearchRequest(bleve.NewMatchPhraseQuery(q))
+	request.Size = size
+
+	r, _, err := i.Query(request)
+	return r, err
+}
@@ -0,0 +1,97 @@
+package index
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// SearchPrefix returns the positions of at most size songs where the value
+// of the given tag starts with prefix, e.g. all songs where the artist
+// starts with "the be". Results are returned in library order.
+//
+// Prefixes are matched against the exact match field of the tag, which holds
+// the entire tag value folded to lower case and with diacritics removed. The
+// prefix is folded the same way, so matching is case-insensitive, and the
+// prefix may span several words. Matching does not start at individual words
+// within the tag; use Search for that.
+func (i *Index) SearchPrefix(field, prefix string, size int) ([]int, error) {
+	return i.searchExactPattern(field, prefix, size, func(term string) query.FieldableQuery {
+		return bleve.NewPrefixQuery(term)
+	})
+}
+
+// SearchWildcard works like SearchPrefix, but matches the tag value against a
+// wildcard pattern, where "*" matches any number of characters, and "?"
+// matches a single character. The pattern must match the entire tag value.
+func (i *Index) SearchWildcard(field, pattern string, size int) ([]int, error) {
+	return i.searchExactPattern(field, pattern, size, func(term string) query.FieldableQuery {
+		return bleve.NewWildcardQuery(term)
+	})
+}
+
+// SearchRegex returns the positions of at most size songs where the value
+// of the given tag matches a regular expression, e.g. "^intro", in library
+// order. The syntax is that of the regexp package, and the pattern may match
+// anywhere in the tag value unless anchored with "^" or "$". Matching is
+// case-insensitive, and diacritics are removed from tag values before
+// matching, as for SearchPrefix.
+//
+// Regular expressions are matched against every distinct value of the tag,
+// which can be slow on large indexes.
+func (i *Index) SearchRegex(field, pattern string, size int) ([]int, error) {
+	name, err := i.indexedField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, fmt.Errorf("Invalid regular expression: %s", err)
+	}
+
+	// Bleve requires the expression to match the entire tag value.
+	q := bleve.NewRegexpQuery(`(?is).*(?:` + pattern + `).*`)
+	q.SetField(exactFieldName(name))
+	request := bleve.NewSearchRequest(q)
+	request.Size = size
+
+	r, _, err := i.Query(request)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Ints(r)
+
+	return r, nil
+}
+
+// searchExactPattern runs a term query, built by newQuery, against the exact
+// match field of a tag. Results are returned in library order.
+func (i *Index) searchExactPattern(field, pattern string, size int, newQuery func(string) query.FieldableQuery) ([]int, error) {
+	name, err := i.indexedField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	term, err := i.exactTerm(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	q := newQuery(term)
+	q.SetField(exactFieldName(name))
+	request := bleve.NewSearchRequest(q)
+	request.Size = size
+
+	r, _, err := i.Query(request)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Ints(r)
+
+	return r, nil
+}
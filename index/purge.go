@@ -0,0 +1,68 @@
+package index
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ambientsound/pms/console"
+)
+
+// Purge deletes the index from disk, and replaces it with an empty index
+// using the configured mapping, e.g. to recover from a corrupt index which
+// cannot be cleared with Clear. The index version is reset to zero, so that
+// the index is rebuilt the next time it is synchronized with the MPD library.
+// Query aliases are kept.
+//
+// If the new index cannot be created, the index is closed, and must be
+// opened again with New.
+func (i *Index) Purge() error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.closed {
+		return ErrIndexClosed
+	}
+	if i.indexing {
+		return ErrIndexingInProgress
+	}
+
+	if i.memOnly {
+		index, err := createInMemory(i.mapping)
+		if err != nil {
+			return err
+		}
+		i.bleveIndex.Close()
+		i.bleveIndex = index
+		i.lastIndexed = time.Time{}
+		return i.setVersion(0)
+	}
+
+	// A corrupt index may fail to close cleanly; it is deleted regardless.
+	if err := i.bleveIndex.Close(); err != nil {
+		console.Log("Error while closing search index before purging it: %s", err)
+	}
+	i.closed = true
+
+	for _, path := range []string{i.indexPath, i.nextPath(), i.oldPath(), i.indexedPath} {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("while removing %s: %s", path, err)
+		}
+	}
+	i.lastIndexed = time.Time{}
+
+	index, err := create(i.indexPath, i.mapping)
+	if err != nil {
+		return fmt.Errorf("while creating index at %s: %s", i.indexPath, err)
+	}
+	i.bleveIndex = index
+	i.closed = false
+
+	if err = i.writeSchema(); err != nil {
+		return fmt.Errorf("while writing schema version to %s: %s", i.schemaPath, err)
+	}
+
+	console.Log("Purged search index at %s", i.indexPath)
+
+	return i.setVersion(0)
+}
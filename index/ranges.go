@@ -0,0 +1,54 @@
+package index
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// rangeToken matches numeric comparisons in natural language queries, such as
+// "year:>=1990" or "time:<300".
+var rangeToken = regexp.MustCompile(`^(\w+):(>=|<=|>|<)(-?[0-9]+(?:\.[0-9]+)?)$`)
+
+// rangeFilters removes numeric comparisons on tags with numeric fields, e.g.
+// "year:>=1990", "track:<3" or "time:<300", from a query, and returns the
+// remaining query along with the comparisons as numeric range queries. Songs
+// must satisfy all the comparisons to match. Comparisons on other tags are
+// left in the query.
+func (i *Index) rangeFilters(q string) (string, []query.Query) {
+	tokens := strings.Fields(q)
+	remaining := make([]string, 0, len(tokens))
+	filters := make([]query.Query, 0)
+
+	for _, token := range tokens {
+		match := rangeToken.FindStringSubmatch(token)
+		if match == nil {
+			remaining = append(remaining, token)
+			continue
+		}
+
+		name, err := i.numericField(match[1])
+		if err != nil {
+			remaining = append(remaining, token)
+			continue
+		}
+
+		value, _ := strconv.ParseFloat(match[3], 64)
+		var min, max *float64
+		inclusive := strings.HasSuffix(match[2], "=")
+		if match[2][0] == '>' {
+			min = &value
+		} else {
+			max = &value
+		}
+
+		filter := bleve.NewNumericRangeInclusiveQuery(min, max, &inclusive, &inclusive)
+		filter.SetField(name)
+		filters = append(filters, filter)
+	}
+
+	return strings.Join(remaining, " "), filters
+}
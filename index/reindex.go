@@ -0,0 +1,70 @@
+package index
+
+import (
+	"context"
+
+	"github.com/ambientsound/pms/console"
+	"github.com/ambientsound/pms/song"
+)
+
+// StartAutoReindex starts a background job which rebuilds the index whenever
+// a library version different from the indexed version is received on the
+// versions channel. The songs to index are retrieved by calling fetch.
+//
+// The job stops when the context is cancelled or the versions channel is
+// closed. Cancelling the context also aborts any reindex in progress. The
+// returned channel is closed once the job has stopped.
+func (i *Index) StartAutoReindex(ctx context.Context, versions <-chan int, fetch func() ([]*song.Song, error)) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case version, ok := <-versions:
+				if !ok {
+					return
+				}
+				if version == i.Version() {
+					continue
+				}
+				if err := i.reindexContext(ctx, version, fetch); err != nil {
+					console.Log("Automatic reindex to library version %d failed: %s", version, err)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// Reindex rebuilds the index from the given songs if the MPD library version
+// current is newer than the indexed version, and records the new version on
+// success. True is returned if the index was rebuilt. If the index is already
+// up to date, nothing is done, and false is returned.
+func (i *Index) Reindex(current int, songs []*song.Song) (bool, error) {
+	if current <= i.Version() {
+		return false, nil
+	}
+
+	if err := i.IndexFull(songs, make(chan int)); err != nil {
+		return false, err
+	}
+
+	return true, i.SetVersion(current)
+}
+
+// reindexContext fetches a song list and indexes it, aborting if the context
+// is cancelled. On success, the index version is set to the given version.
+func (i *Index) reindexContext(ctx context.Context, version int, fetch func() ([]*song.Song, error)) error {
+	songs, err := fetch()
+	if err != nil {
+		return err
+	}
+
+	if err = i.IndexFullContext(ctx, songs); err != nil {
+		return err
+	}
+
+	return i.SetVersion(version)
+}
@@ -0,0 +1,45 @@
+package index
+
+// SearchResult is the result of a natural language search.
+type SearchResult struct {
+	Query     string
+	Positions []int
+}
+
+// rememberedQuery is a query stored by SearchRemember.
+type rememberedQuery struct {
+	query string
+	size  int
+}
+
+// SearchRemember works like Search, but also remembers the query on behalf
+// of the caller identified by token, so that it can be executed again later
+// using RerunLast.
+func (i *Index) SearchRemember(token, q string, size int) (SearchResult, error) {
+	i.mutex.Lock()
+	if i.lastQueries == nil {
+		i.lastQueries = make(map[string]rememberedQuery)
+	}
+	i.lastQueries[token] = rememberedQuery{query: q, size: size}
+	i.mutex.Unlock()
+
+	r, err := i.Search(q, size)
+	return SearchResult{Query: q, Positions: r}, err
+}
+
+// RerunLast executes the last query made with SearchRemember by the caller
+// identified by token again. This is useful for keeping search results up to
+// date when the index changes. ErrNotFound is returned if the caller has not
+// made any queries.
+func (i *Index) RerunLast(token string) (SearchResult, error) {
+	i.mutex.RLock()
+	last, ok := i.lastQueries[token]
+	i.mutex.RUnlock()
+
+	if !ok {
+		return SearchResult{}, ErrNotFound
+	}
+
+	r, err := i.Search(last.query, last.size)
+	return SearchResult{Query: last.query, Positions: r}, err
+}
@@ -0,0 +1,82 @@
+package index
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/ambientsound/pms/console"
+)
+
+// INDEX_SCHEMA_VERSION is the version of the index mapping. It must be
+// incremented whenever the mapping changes, which causes existing indexes
+// to be deleted and recreated, and the library to be reindexed.
+const INDEX_SCHEMA_VERSION int = 6
+
+// migrateSchema deletes the Bleve index if it was created with another
+// schema version than INDEX_SCHEMA_VERSION, with another analyzer than the
+// configured one, or without encryption although a passphrase is set, so
+// that a new index is created in its place. Indexes without a schema version
+// are treated as outdated. An encrypted index opened without a passphrase is
+// kept, and ErrPassphraseRequired is returned.
+func (i *Index) migrateSchema() error {
+	if _, err := os.Stat(i.indexPath); err != nil {
+		return nil
+	}
+
+	schema, err := i.readSchema()
+	analyzer := i.readSchemaAnalyzer()
+	outdated := err != nil || schema != INDEX_SCHEMA_VERSION
+	encrypted, err := isEncrypted(i.indexPath)
+	encryption := err == nil && encrypted != (len(i.mapping.passphrase) > 0)
+
+	// A missing passphrase is probably a configuration mistake; keep the
+	// encrypted index rather than replacing it.
+	if encryption && encrypted {
+		return ErrPassphraseRequired
+	}
+	if !outdated && !encryption && analyzer == i.mapping.analyzer {
+		return nil
+	}
+
+	locked, err := isLocked(storePath(i.indexPath))
+	if err != nil {
+		return err
+	} else if locked {
+		return ErrIndexLocked
+	}
+
+	if outdated {
+		console.Log("Search index schema version %d is outdated, recreating index with version %d.", schema, INDEX_SCHEMA_VERSION)
+	} else if encryption {
+		console.Log("Search index is not encrypted, recreating encrypted index.")
+	} else {
+		console.Log("Search index uses the '%s' analyzer, recreating index with the '%s' analyzer.", analyzer, i.mapping.analyzer)
+	}
+
+	return os.RemoveAll(i.indexPath)
+}
+
+// writeSchema writes INDEX_SCHEMA_VERSION and the analyzer setting to the
+// schema file.
+func (i *Index) writeSchema() error {
+	str := fmt.Sprintf("%d\n%s\n", INDEX_SCHEMA_VERSION, i.mapping.analyzer)
+	return writeFileAtomic(i.schemaPath, []byte(str))
+}
+
+// readSchema reads the index schema version from the schema file.
+func (i *Index) readSchema() (int, error) {
+	file, err := os.Open(i.schemaPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if scanner.Scan() {
+		return strconv.Atoi(scanner.Text())
+	}
+
+	return 0, fmt.Errorf("No data in index schema file")
+}
@@ -0,0 +1,66 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/ambientsound/pms/console"
+	"github.com/blevesearch/bleve"
+)
+
+// Scoring models for ranking search results.
+//
+// The Bleve version used by PMS implements TF-IDF scoring only. BM25, which
+// tends to rank short fields such as song tags better, is available from
+// Bleve 2.5 onwards, and is accepted here so that configurations can name it,
+// but selecting it is currently an error.
+const (
+	SCORING_TFIDF = "tfidf"
+	SCORING_BM25  = "bm25"
+)
+
+// scoringKey is the key under which the scoring model is stored in the index.
+var scoringKey = []byte("pms_scoring_model")
+
+// ScoringModel selects the scoring model used to rank search results. The
+// default is SCORING_TFIDF. The model is recorded in the index when it is
+// created or rebuilt, and an existing index keeps its model until then.
+func ScoringModel(model string) Option {
+	return func(i *Index) {
+		i.mapping.scoring = model
+	}
+}
+
+// validateScoring returns an error if a scoring model is not available.
+func validateScoring(model string) error {
+	switch model {
+	case SCORING_TFIDF:
+		return nil
+	case SCORING_BM25:
+		return fmt.Errorf("Scoring model '%s' requires a newer version of Bleve; only '%s' is available", model, SCORING_TFIDF)
+	}
+	return fmt.Errorf("Unknown scoring model '%s'", model)
+}
+
+// writeScoring records the scoring model in a newly created Bleve index.
+func writeScoring(index bleve.Index, model string) error {
+	return index.SetInternal(scoringKey, []byte(model))
+}
+
+// Scoring returns the scoring model used by the index.
+func (i *Index) Scoring() string {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	model, err := i.bleveIndex.GetInternal(scoringKey)
+	if err != nil || len(model) == 0 {
+		return SCORING_TFIDF
+	}
+	return string(model)
+}
+
+// checkScoring logs a warning if an opened index uses another scoring model
+// than the one requested.
+func (i *Index) checkScoring() {
+	if model := i.Scoring(); model != i.mapping.scoring {
+		console.Log("Search index uses the '%s' scoring model instead of '%s'; rebuild the index to change it.", model, i.mapping.scoring)
+	}
+}
@@ -0,0 +1,476 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ambientsound/pms/song"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// EDIT_DISTANCE_CANDIDATES is the number of search hits considered for each
+// result returned by SearchByEditDistance.
+const EDIT_DISTANCE_CANDIDATES int = 5
+
+// RECENTLY_PLAYED_BOOST is the boost given to recently played songs in
+// natural language searches.
+const RECENTLY_PLAYED_BOOST float64 = 0.5
+
+// QueryProfile contains the time spent in each phase of a search query.
+type QueryProfile struct {
+	Normalize time.Duration // cleaning up the query string
+	Search    time.Duration // running the Bleve search
+	Collect   time.Duration // threshold filtering and ID conversion
+	Total     time.Duration
+}
+
+// SetRecentlyPlayed configures a list of recently played song URIs. Songs in
+// this list are ranked slightly higher in natural language searches, because
+// they are probably what the user is looking for. Play history is not
+// tracked by the index itself; the caller must supply it.
+func (i *Index) SetRecentlyPlayed(uris []string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.recentlyPlayed = uris
+}
+
+// boostRecentlyPlayed wraps a query so that recently played songs matching
+// the query get a higher score. Songs not in the play history are unaffected.
+func (i *Index) boostRecentlyPlayed(q query.Query) query.Query {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	if len(i.recentlyPlayed) == 0 {
+		return q
+	}
+
+	boolean := bleve.NewBooleanQuery()
+	boolean.AddMust(q)
+	for _, uri := range i.recentlyPlayed {
+		recent := exactQuery("File", uri)
+		recent.SetBoost(RECENTLY_PLAYED_BOOST)
+		boolean.AddShould(recent)
+	}
+
+	return boolean
+}
+
+// ScoredPosition is a search result, containing the position of a matching
+// song along with its relevance score.
+type ScoredPosition struct {
+	Pos   int
+	Score float64
+}
+
+// normalizeQuery cleans up a natural language query string before it is
+// handed to Bleve.
+func normalizeQuery(q string) string {
+	return strings.Join(strings.Fields(q), " ")
+}
+
+// Search runs a natural language query against the index, and returns the
+// positions of at most size matching songs. See SearchWith for more search
+// modes.
+func (i *Index) Search(q string, size int) ([]int, error) {
+	r, err := i.SearchWith(q, SearchOptions{Size: size})
+	return r.Positions, err
+}
+
+// SearchScored works like Search, but also returns the relevance score of
+// each result, for callers who want to combine it with their own ranking.
+// Results scoring below the score threshold are discarded.
+func (i *Index) SearchScored(q string, size int) ([]ScoredPosition, error) {
+	request := i.searchRequest(normalizeQuery(q), size)
+	r, _, err := i.queryScored(request, i.ScoreThreshold(), nil)
+	return r, err
+}
+
+// SearchPaged works like Search, but skips the first from results, for
+// showing results one page at a time. The total number of matching songs is
+// also returned. The total is counted before the score threshold is applied,
+// so it may exceed the number of results on all pages.
+func (i *Index) SearchPaged(q string, from, size int) ([]int, int, error) {
+	request := i.searchRequest(normalizeQuery(q), size)
+	request.From = from
+
+	r, sr, err := i.query(request, i.ScoreThreshold(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return r, int(sr.Total), nil
+}
+
+// SearchTotal works like Search, but also returns the total number of songs
+// matching the query, including those beyond size. As with SearchPaged, the
+// total is counted before the score threshold is applied.
+func (i *Index) SearchTotal(q string, size int) ([]int, uint64, error) {
+	request := i.searchRequest(normalizeQuery(q), size)
+
+	r, sr, err := i.query(request, i.ScoreThreshold(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return r, sr.Total, nil
+}
+
+// searchRequest returns a search request for a natural language query.
+// Query aliases are expanded, and numeric comparisons such as "year:>=1990"
+// are applied as filters; see rangeFilters.
+func (i *Index) searchRequest(q string, size int) *bleve.SearchRequest {
+	q = i.expandAliases(q)
+	q, filters := i.rangeFilters(q)
+
+	var base query.Query
+	if len(q) == 0 && len(filters) > 0 {
+		base = bleve.NewMatchAllQuery()
+	} else {
+		base = i.boostFields(q, bleve.NewQueryStringQuery(q))
+	}
+	base = i.boostRecentlyPlayed(base)
+
+	// Like the position filter in SearchWithin, numeric comparisons must not
+	// change the relevance scores.
+	if len(filters) > 0 {
+		conjunction := bleve.NewConjunctionQuery(base)
+		for _, filter := range filters {
+			conjunction.AddQuery(&additiveQuery{Query: filter, scale: 0})
+		}
+		base = conjunction
+	}
+
+	request := bleve.NewSearchRequest(base)
+	request.Size = size
+	return request
+}
+
+// SearchWithin works like Search, but only returns songs whose positions are
+// in the given list, e.g. the songs currently shown in a view. An empty list
+// matches nothing.
+func (i *Index) SearchWithin(q string, positions []int, size int) ([]int, error) {
+	if len(positions) == 0 {
+		return []int{}, nil
+	}
+
+	ids := make([]string, len(positions))
+	for n, pos := range positions {
+		ids[n] = strconv.Itoa(pos)
+	}
+
+	// The position filter must not change the relevance scores, or results
+	// would be compared against the score threshold differently than in Search.
+	request := i.searchRequest(normalizeQuery(q), size)
+	filter := &additiveQuery{Query: bleve.NewDocIDQuery(ids), scale: 0}
+	request.Query = bleve.NewConjunctionQuery(request.Query, filter)
+
+	r, _, err := i.query(request, i.ScoreThreshold(), nil)
+	return r, err
+}
+
+// SearchProfile works like Search, but also returns a breakdown of the time
+// spent in each phase of the query.
+func (i *Index) SearchProfile(q string, size int) ([]int, QueryProfile, error) {
+	profile := QueryProfile{}
+	timer := time.Now()
+
+	q = normalizeQuery(q)
+	profile.Normalize = time.Since(timer)
+
+	request := i.searchRequest(q, size)
+	r, _, err := i.query(request, i.ScoreThreshold(), &profile)
+	profile.Total = time.Since(timer)
+
+	return r, profile, err
+}
+
+// SearchField searches a single tag field, e.g. "artist", for the given
+// value, and returns the positions of at most size matching songs. The value
+// is matched as text; no query syntax is interpreted.
+func (i *Index) SearchField(field, value string, size int) ([]int, error) {
+	name, err := i.indexedField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	query := bleve.NewMatchQuery(normalizeQuery(value))
+	query.SetField(name)
+	request := bleve.NewSearchRequest(query)
+	request.Size = size
+
+	r, _, err := i.Query(request)
+	return r, err
+}
+
+// SearchNumericRange returns the positions of at most size songs where the
+// numeric value of the given tag, e.g. "track", "disc" or "year", lies
+// between min and max, inclusive. The "time" tag holds the song duration in
+// seconds. Songs without a numeric value for the tag, including songs of
+// unknown duration, do not match. The first matching songs are returned, in
+// library order.
+func (i *Index) SearchNumericRange(field string, min, max float64, size int) ([]int, error) {
+	name, err := i.numericField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	inclusive := true
+	query := bleve.NewNumericRangeInclusiveQuery(&min, &max, &inclusive, &inclusive)
+	query.SetField(name)
+
+	return i.firstMatches(query, size)
+}
+
+// SearchDateRange returns the positions of at most size songs where the
+// given date tag, e.g. "modified", lies between start and end, inclusive.
+// Songs without a valid timestamp do not match. The first matching songs are
+// returned, in library order.
+func (i *Index) SearchDateRange(field string, start, end time.Time, size int) ([]int, error) {
+	name, err := i.dateField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	inclusive := true
+	query := bleve.NewDateRangeInclusiveQuery(start, end, &inclusive, &inclusive)
+	query.SetField(name)
+
+	return i.firstMatches(query, size)
+}
+
+// firstMatches returns the positions of the first size songs in library
+// order matching a query. Matches are not scored or filtered by relevance.
+// All matches are fetched and sorted before the results are cut to size,
+// since queries such as range queries give every match the same score, and
+// the top scoring hits would be an arbitrary selection. As in other searches,
+// a size of zero or less gives SEARCH_RESULT_SIZE results, up to the limit
+// set by SetMaxResults.
+func (i *Index) firstMatches(q query.Query, size int) ([]int, error) {
+	count, err := i.DocCount()
+	if err != nil {
+		return nil, err
+	}
+
+	request := bleve.NewSearchRequest(q)
+	request.Size = int(count)
+
+	r, _, err := i.queryAll(request)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Ints(r)
+
+	request.Size = size
+	if limit := i.limitRequest(request).Size; len(r) > limit {
+		r = r[:limit]
+	}
+
+	return r, nil
+}
+
+// SearchByEditDistance searches a single tag field for the query, and returns
+// the positions of at most size songs, ordered by the Levenshtein distance
+// between the query and the field value. Songs with the same distance keep
+// the order of their search score. If size is zero or less, all matching
+// songs are returned, up to SEARCH_RESULT_SIZE and the limit set by
+// SetMaxResults.
+func (i *Index) SearchByEditDistance(q, field string, size int) ([]int, error) {
+	name, err := i.indexedField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	q = normalizeQuery(q)
+	query := bleve.NewMatchQuery(q)
+	query.SetField(name)
+
+	// Fetch more candidates than requested, so that close matches with a
+	// low search score still have a chance to be ranked.
+	request := bleve.NewSearchRequest(query)
+	request.Size = 0
+	if size > 0 {
+		request.Size = size * EDIT_DISTANCE_CANDIDATES
+	}
+	request.Fields = []string{name}
+
+	_, sr, err := i.Query(request)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		pos      int
+		distance int
+	}
+
+	lq := strings.ToLower(q)
+	candidates := make([]candidate, 0, len(sr.Hits))
+	for _, hit := range sr.Hits {
+		pos, err := hitPosition(hit)
+		if err != nil {
+			return nil, err
+		}
+		value, _ := hit.Fields[name].(string)
+		candidates = append(candidates, candidate{
+			pos:      pos,
+			distance: levenshtein(lq, strings.ToLower(value)),
+		})
+	}
+
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return candidates[a].distance < candidates[b].distance
+	})
+
+	if size <= 0 || size > len(candidates) {
+		size = len(candidates)
+	}
+
+	r := make([]int, size)
+	for n := range r {
+		r[n] = candidates[n].pos
+	}
+
+	return r, nil
+}
+
+// SearchBool returns the positions of at most size songs where the given
+// boolean field, e.g. "has_lyrics", has the specified value. Songs where the
+// field is unknown, e.g. songs without lyrics and comment tags for
+// "has_lyrics", match neither value.
+func (i *Index) SearchBool(field string, value bool, size int) ([]int, error) {
+	name, err := i.indexedField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	query := bleve.NewBoolFieldQuery(value)
+	query.SetField(name)
+	request := bleve.NewSearchRequest(query)
+	request.Size = size
+
+	r, _, err := i.Query(request)
+	return r, err
+}
+
+// FieldValue returns the indexed value of a song tag, e.g. "title", for the
+// song at the given position. ErrNotFound is returned if the position is not
+// indexed, or if the tag is empty or not stored; see StoredFields.
+func (i *Index) FieldValue(pos int, field string) (string, error) {
+	name, err := fieldName(field)
+	if err != nil {
+		return "", err
+	}
+
+	i.mutex.RLock()
+	if i.closed {
+		i.mutex.RUnlock()
+		return "", ErrIndexClosed
+	}
+	doc, err := i.bleveIndex.Document(strconv.Itoa(pos))
+	i.mutex.RUnlock()
+	if err != nil {
+		return "", err
+	} else if doc == nil {
+		return "", ErrNotFound
+	}
+
+	for _, f := range doc.Fields {
+		if f.Name() != name {
+			continue
+		}
+		switch f := f.(type) {
+		case *document.BooleanField:
+			b, err := f.Boolean()
+			return strconv.FormatBool(b), err
+		case *document.NumericField:
+			n, err := f.Number()
+			return strconv.FormatFloat(n, 'f', -1, 64), err
+		case *document.DateTimeField:
+			t, err := f.DateTime()
+			return t.UTC().Format(time.RFC3339), err
+		default:
+			if len(f.Value()) == 0 {
+				return "", ErrNotFound
+			}
+			return string(f.Value()), nil
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+// SameAlbum returns the positions of the first size songs from the same
+// album as the given song, in library order. Songs match if both their album and
+// album artist tags are exactly equal to those of the given song, ignoring
+// case. If the song has no album artist, only the album tag is compared.
+func (i *Index) SameAlbum(s *song.Song, size int) ([]int, error) {
+	album := s.StringTags["album"]
+	if len(album) == 0 {
+		return nil, fmt.Errorf("Song has no album tag")
+	}
+	if _, err := i.indexedField("album"); err != nil {
+		return nil, err
+	}
+
+	query := bleve.NewConjunctionQuery(exactQuery("Album", album))
+	if albumartist := s.StringTags["albumartist"]; len(albumartist) > 0 {
+		query.AddQuery(exactQuery("Albumartist", albumartist))
+	}
+
+	return i.firstMatches(query, size)
+}
+
+// PositionsForField returns the positions of all songs where the given tag
+// is exactly equal to value, ignoring case and diacritics. Unlike the phrase
+// matches used by Isolate, a value only matches entire tag values, e.g.
+// "OK Computer" does not match "OK Computer OKNOTOK". The results are not
+// scored or filtered by relevance, and are returned in library order.
+func (i *Index) PositionsForField(field, value string) ([]int, error) {
+	name, err := i.indexedField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := i.DocCount()
+	if err != nil {
+		return nil, err
+	}
+
+	request := bleve.NewSearchRequest(exactQuery(name, value))
+	request.Size = int(count)
+
+	r, _, err := i.queryAll(request)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Ints(r)
+
+	return r, nil
+}
+
+// FilterExact returns the positions of all songs where the given tag is
+// exactly equal to value, ignoring case and diacritics, in library order.
+// The value is matched against the keyword-analyzed exact sub-field of the
+// tag, so that it only matches entire tag values. It works like
+// PositionsForField.
+func (i *Index) FilterExact(field, value string) ([]int, error) {
+	return i.PositionsForField(field, value)
+}
+
+// SearchUnder returns the positions of all songs located in the given
+// directory, or any of its subdirectories, in library order. The directory
+// is relative to the MPD library root.
+func (i *Index) SearchUnder(dir string) ([]int, error) {
+	dir = strings.Trim(dir, "/")
+	if len(dir) == 0 {
+		return nil, fmt.Errorf("No directory given")
+	}
+	return i.PositionsForField("directories", dir)
+}
@@ -0,0 +1,49 @@
+package index
+
+import (
+	"net"
+
+	"github.com/ambientsound/pms/console"
+)
+
+// Server configures the address of the MPD server whose library is indexed.
+// The address is recorded in the state file, and checked when the index is
+// opened. If the index was built for another server, its library version is
+// reset, so that the index is rebuilt from the library of this server.
+func Server(host, port string) Option {
+	return func(i *Index) {
+		i.server = net.JoinHostPort(host, port)
+	}
+}
+
+// readServer reads the MPD server address from the state file. It is stored
+// on the line following the library version. An empty string is returned if
+// the state file does not contain an address.
+func (i *Index) readServer() (string, error) {
+	lines, err := i.readState()
+	if err != nil || len(lines) < 2 {
+		return "", err
+	}
+
+	return lines[1], nil
+}
+
+// checkServer resets the library version if the index was built for another
+// MPD server than the one configured with Server. Indexes without a
+// recorded server address are assumed to belong to the configured server.
+func (i *Index) checkServer() error {
+	if len(i.server) == 0 {
+		return nil
+	}
+
+	server, err := i.readServer()
+	if err != nil {
+		return err
+	} else if len(server) == 0 || server == i.server {
+		return nil
+	}
+
+	console.Log("Search index was built for MPD server %s, not %s; it will be rebuilt.", server, i.server)
+
+	return i.SetVersion(0)
+}
@@ -0,0 +1,77 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IndexSet holds the search indexes of several MPD servers, so that they can
+// be searched together.
+type IndexSet struct {
+	indexes []*Index
+}
+
+// NewIndexSet returns an IndexSet holding the given indexes.
+func NewIndexSet(indexes ...*Index) *IndexSet {
+	return &IndexSet{
+		indexes: indexes,
+	}
+}
+
+// Indexes returns the indexes in the set.
+func (s *IndexSet) Indexes() []*Index {
+	return s.indexes
+}
+
+// SetError is returned by IndexSet when searching some of its indexes
+// failed. It holds the error returned by each failing index.
+type SetError map[*Index]error
+
+func (e SetError) Error() string {
+	messages := make([]string, 0, len(e))
+	for idx, err := range e {
+		messages = append(messages, fmt.Sprintf("%s: %s", idx.path, err))
+	}
+	sort.Strings(messages)
+	return fmt.Sprintf("Search failed in %d indexes: %s", len(e), strings.Join(messages, "; "))
+}
+
+// Search runs a natural language query against all indexes in the set
+// concurrently, and returns the positions of at most size matching songs
+// from each index. Positions refer to the song list of the index they were
+// found in.
+//
+// A failing index does not abort the search. Results from the other indexes
+// are returned along with a SetError describing the failures.
+func (s *IndexSet) Search(q string, size int) (map[*Index][]int, error) {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	results := make(map[*Index][]int, len(s.indexes))
+	errs := make(SetError)
+
+	for _, idx := range s.indexes {
+		wg.Add(1)
+		go func(idx *Index) {
+			defer wg.Done()
+			r, err := idx.Search(q, size)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				errs[idx] = err
+				return
+			}
+			results[idx] = r
+		}(idx)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return results, errs
+	}
+
+	return results, nil
+}
@@ -0,0 +1,84 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/ambientsound/pms/song"
+	"github.com/blevesearch/bleve"
+)
+
+// similarTags lists the tags compared by Similar, and the weight given to
+// songs sharing each tag.
+var similarTags = []struct {
+	tag    string
+	weight float64
+}{
+	{"artist", 3},
+	{"albumartist", 2},
+	{"genre", 2},
+	{"album", 1},
+}
+
+// tagWords splits a tag value into its words, dropping punctuation, so that
+// e.g. "Rock; Alternative" yields "Rock" and "Alternative".
+func tagWords(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Similar returns the positions of at most size songs resembling the given
+// song, ordered by decreasing similarity. Songs are compared on the artist,
+// album artist, genre and album tags. A song sharing the entire value of a tag
+// scores highest, but songs also match on the words of each value, at half
+// the weight; a word matches any word in the other song's tag that starts with
+// it. Thus a genre of "Rock; Alternative" is similar to both "Rock" and
+// "Alternative". The more tags and words songs share, and the fewer songs
+// have them, the more similar they are. The given song itself is not
+// returned. Tags which are empty in the given song, or left out of the index,
+// are ignored.
+//
+// Similar songs are returned regardless of the score threshold.
+func (i *Index) Similar(s *song.Song, size int) ([]int, error) {
+	query := bleve.NewBooleanQuery()
+	clauses := 0
+
+	for _, similar := range similarTags {
+		value := s.StringTags[similar.tag]
+		if len(value) == 0 {
+			continue
+		}
+		name, err := i.indexedField(similar.tag)
+		if err != nil {
+			continue
+		}
+		match := exactQuery(name, value)
+		match.SetBoost(similar.weight)
+		query.AddShould(match)
+		for _, word := range tagWords(value) {
+			match = bleve.NewMatchQuery(word)
+			match.SetField(name)
+			match.Analyzer = EXACT_ANALYZER
+			match.SetBoost(similar.weight / 2)
+			query.AddShould(match)
+		}
+		clauses++
+	}
+
+	if clauses == 0 {
+		return nil, fmt.Errorf("Song has no tags to compare")
+	}
+
+	if file := s.StringTags["file"]; len(file) > 0 {
+		query.AddMustNot(exactQuery("File", file))
+	}
+
+	request := bleve.NewSearchRequest(query)
+	request.Size = size
+
+	r, _, err := i.query(request, 0, nil)
+
+	return r, err
+}
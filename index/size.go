@@ -0,0 +1,57 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/ambientsound/pms/console"
+)
+
+// MaxIndexSize configures a size limit for the index on disk, in bytes. When
+// the index is found to be larger than the limit after opening, indexing, or
+// updating songs with IndexPartial, DeleteAt or a transaction, a warning is
+// logged. If warn is not nil, it is called with the current
+// size of the index.
+func MaxIndexSize(size int64, warn func(size int64)) Option {
+	return func(i *Index) {
+		i.maxSize = size
+		i.sizeWarning = warn
+	}
+}
+
+// diskSize returns the total size of the files making up the Bleve index.
+func (i *Index) diskSize() (int64, error) {
+	var size int64
+	err := filepath.Walk(i.indexPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// checkSize warns if the index has grown beyond the configured size limit.
+func (i *Index) checkSize() {
+	if i.maxSize <= 0 || i.memOnly {
+		return
+	}
+
+	size, err := i.diskSize()
+	if err != nil {
+		console.Log("Unable to determine search index size: %s", err)
+		return
+	}
+
+	if size <= i.maxSize {
+		return
+	}
+
+	console.Log("Search index size of %d bytes exceeds the limit of %d bytes; consider optimizing or rebuilding the index.", size, i.maxSize)
+	if i.sizeWarning != nil {
+		i.sizeWarning(size)
+	}
+}
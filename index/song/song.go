@@ -3,9 +3,22 @@
 package song
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/ambientsound/pms/song"
 )
 
+// Timestamp is a date and time in RFC 3339 format, e.g.
+// "2017-03-01T12:00:00Z". Fields of this type are indexed as dates.
+type Timestamp string
+
 // Song is a Bleve document representing a song.Song object.
 type Song struct {
 	Album       string
@@ -13,8 +26,33 @@ type Song struct {
 	Artist      string
 	File        string
 	Genre       string
+	Grouping    string
+	Mood        string
 	Title       string
 	Year        string
+	Decade      string
+
+	// Numeric fields are nil if the tag is missing or not a number.
+	TrackNumber *float64
+	DiscNumber  *float64
+	YearNumber  *float64
+	TimeNumber  *float64
+
+	// Modified is the time the file was last modified, or empty if unknown.
+	Modified Timestamp
+
+	// HasLyrics is nil if the song has neither a lyrics nor a comment
+	// tag, and false if the tags are present but empty.
+	HasLyrics   *bool
+	Directory   string
+	Directories []string
+
+	// Path is the file name, indexed as the separate words of each
+	// directory and file name; see PATH_ANALYZER.
+	Path string
+
+	// Hash is a checksum of all the other fields, used to detect changes.
+	Hash string
 }
 
 // New generates a indexable Song document, containing some fields from the song.Song type.
@@ -24,7 +62,112 @@ func New(s *song.Song) (is Song) {
 	is.Artist = s.StringTags["artist"]
 	is.File = s.StringTags["file"]
 	is.Genre = s.StringTags["genre"]
+	is.Grouping = s.StringTags["grouping"]
+	is.Mood = s.StringTags["mood"]
 	is.Title = s.StringTags["title"]
 	is.Year = s.StringTags["year"]
+	is.Decade = decade(is.Year)
+	is.TrackNumber = number(s.StringTags["track"])
+	is.DiscNumber = number(s.StringTags["disc"])
+	is.YearNumber = number(is.Year)
+	is.TimeNumber = duration(s.Time)
+	is.Modified = timestamp(s.StringTags["last-modified"])
+	is.HasLyrics = hasText(s, "lyrics", "comment")
+	is.Directory, is.Directories = directories(is.File)
+	is.Path = is.File
+	is.Hash = is.checksum()
 	return
 }
+
+// checksum returns a hash of the contents of the document.
+func (is Song) checksum() string {
+	is.Hash = ""
+	// Marshal to JSON rather than printing the struct, so that pointer
+	// fields are hashed by value instead of by address.
+	data, _ := json.Marshal(is)
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// number parses the leading integer of a tag value, e.g. "5/12" yields 5.
+// Nil is returned if the value does not start with a number.
+func number(value string) *float64 {
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	if end < 0 {
+		end = len(value)
+	}
+	n, err := strconv.Atoi(value[:end])
+	if err != nil {
+		return nil
+	}
+	f := float64(n)
+	return &f
+}
+
+// duration returns the duration of a song in seconds. Nil is returned if the
+// duration is unknown.
+func duration(seconds int) *float64 {
+	if seconds <= 0 {
+		return nil
+	}
+	f := float64(seconds)
+	return &f
+}
+
+// timestamp validates a timestamp in the format used by MPD. An empty
+// Timestamp is returned if the value is not a valid timestamp.
+func timestamp(value string) Timestamp {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return ""
+	}
+	return Timestamp(t.UTC().Format(time.RFC3339))
+}
+
+// decade returns the decade of a year, e.g. "1987" yields "1980s". An empty
+// string is returned if the year is missing or invalid.
+func decade(year string) string {
+	y, err := strconv.Atoi(year)
+	if err != nil || y <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%ds", y-y%10)
+}
+
+// directories returns the parent directory of a file, and a list of all its
+// ancestor directories, e.g. "a/b/c.flac" yields "a/b" and ["a", "a/b"].
+// Files in the library root have no parent directory.
+func directories(file string) (string, []string) {
+	dir := path.Dir(file)
+	if dir == "." || dir == "/" {
+		return "", []string{}
+	}
+
+	parts := strings.Split(dir, "/")
+	ancestors := make([]string, len(parts))
+	for i := range parts {
+		ancestors[i] = strings.Join(parts[:i+1], "/")
+	}
+
+	return dir, ancestors
+}
+
+// hasText returns true if any of the given tags contains something other
+// than whitespace, and false if the tags are present but empty. Nil is
+// returned if the song has none of the tags.
+func hasText(s *song.Song, tags ...string) *bool {
+	var result *bool
+	for _, tag := range tags {
+		value, ok := s.StringTags[tag]
+		if !ok {
+			continue
+		}
+		b := len(strings.TrimSpace(value)) > 0
+		if result == nil || b {
+			result = &b
+		}
+	}
+	return result
+}
@@ -0,0 +1,75 @@
+package index
+
+import (
+	"reflect"
+	"strings"
+
+	index_song "github.com/ambientsound/pms/index/song"
+)
+
+// SearchSorted runs a natural language query against the index, and returns
+// the positions of at most size matching songs, ordered by the given tags
+// instead of by relevance. Tags prefixed with "-" are sorted in descending
+// order, e.g. []string{"album", "track"} or []string{"-year"}. The special
+// fields "_score" and "_id" sort by relevance and library order. Songs
+// without a value for a tag are sorted last.
+//
+// Since the results are not ordered by relevance, the score threshold is not
+// applied.
+func (i *Index) SearchSorted(q string, sortFields []string, size int) ([]int, error) {
+	order, err := i.sortOrder(sortFields)
+	if err != nil {
+		return nil, err
+	}
+
+	request := i.searchRequest(normalizeQuery(q), size)
+	request.SortBy(order)
+
+	r, _, err := i.Query(request)
+	return r, err
+}
+
+// sortOrder translates a list of tags, as taken by SearchSorted, into a Bleve
+// sort order.
+func (i *Index) sortOrder(sortFields []string) ([]string, error) {
+	order := make([]string, len(sortFields))
+	for n, tag := range sortFields {
+		prefix := ""
+		if strings.HasPrefix(tag, "-") {
+			prefix = "-"
+		}
+		name, err := i.sortField(strings.TrimPrefix(tag, "-"))
+		if err != nil {
+			return nil, err
+		}
+		order[n] = prefix + name
+	}
+	return order, nil
+}
+
+// sortField returns the index field used to sort by a tag. Numeric tags are
+// sorted by their numeric field, so that e.g. track 10 sorts after track 9.
+// Text tags are sorted by their exact match field, which holds the entire tag
+// value.
+func (i *Index) sortField(tag string) (string, error) {
+	switch tag {
+	case "_score", "_id":
+		return tag, nil
+	}
+
+	if name, err := i.numericField(tag); err == nil {
+		return name, nil
+	}
+
+	name, err := i.indexedField(tag)
+	if err != nil {
+		return "", err
+	}
+
+	field, _ := reflect.TypeOf(index_song.Song{}).FieldByName(name)
+	if isTextField(field) {
+		return exactFieldName(name), nil
+	}
+
+	return name, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package index
+
+// freeSpace returns -1, signifying that the free space is unknown.
+func freeSpace(path string) (int64, error) {
+	return -1, nil
+}
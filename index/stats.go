@@ -0,0 +1,72 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/ambientsound/pms/console"
+	"github.com/blevesearch/bleve"
+)
+
+// IndexStats contains diagnostic information about an index.
+type IndexStats struct {
+	Documents uint64 // number of songs in the index
+	Schema    int    // index schema version; see INDEX_SCHEMA_VERSION
+	Version   int    // MPD library version the index was built from
+	Path      string // directory holding the index and its state
+	Size      int64  // size of the index on disk, in bytes
+}
+
+// DocCount returns the number of songs in the index.
+func (i *Index) DocCount() (uint64, error) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.bleveIndex.DocCount()
+}
+
+// Stats returns diagnostic information about the index. Values which cannot
+// be determined are left at zero, and the error is logged.
+func (i *Index) Stats() IndexStats {
+	stats := IndexStats{
+		Version: i.Version(),
+		Path:    i.path,
+	}
+
+	var err error
+
+	if stats.Documents, err = i.DocCount(); err != nil {
+		console.Log("Unable to count songs in search index: %s", err)
+	}
+	if i.memOnly {
+		stats.Schema = INDEX_SCHEMA_VERSION
+		return stats
+	}
+
+	if stats.Schema, err = i.readSchema(); err != nil {
+		console.Log("Unable to read search index schema version: %s", err)
+	}
+	if stats.Size, err = i.diskSize(); err != nil {
+		console.Log("Unable to determine search index size: %s", err)
+	}
+
+	return stats
+}
+
+// HealthCheck returns an error if the index cannot be searched, e.g. because
+// it has been closed or its files cannot be read. It runs a trivial search,
+// and does not modify the index.
+func (i *Index) HealthCheck() error {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	if i.closed {
+		return ErrIndexClosed
+	}
+
+	request := bleve.NewSearchRequest(bleve.NewMatchAllQuery())
+	request.Size = 0
+	if _, err := i.bleveIndex.Search(request); err != nil {
+		return fmt.Errorf("Search index is unusable: %s", err)
+	}
+
+	return nil
+}
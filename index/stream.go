@@ -0,0 +1,100 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ambientsound/pms/console"
+	"github.com/ambientsound/pms/song"
+)
+
+// IndexStream works like IndexFullContext, but reads the songs to index from
+// a channel, so that indexing can start while the song list is still being
+// retrieved. Songs are indexed under their position in the stream, starting
+// at zero, and committed whenever a batch is full. The index is complete
+// when the channel is closed.
+//
+// As the number of songs is not known in advance, callbacks registered with
+// OnBatchCommitted receive a total of zero.
+func (i *Index) IndexStream(ctx context.Context, songs <-chan *song.Song) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := i.startIndexing(); err != nil {
+		return err
+	}
+	defer i.stopIndexing()
+
+	next, err := i.createNext()
+	if err != nil {
+		return err
+	}
+
+	shutdown, stop := shutdownOnCancel(ctx)
+	defer stop()
+
+	count := 0
+	batchSize := i.BatchSize()
+	b := next.NewBatch()
+	started := time.Now()
+	console.Log("Start streaming index.")
+
+	// fail discards the partially built index.
+	fail := func(err error) error {
+		i.discardNext(next)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	// flush commits the songs added to the batch since the last flush.
+	flush := func() error {
+		console.Log("Indexing songs %d...", count)
+		if err := next.Batch(b); err != nil {
+			console.Log("Failed to index songs %d-%d: %s", count-b.Size(), count-1, err)
+			return err
+		}
+		i.batchMetrics(b.Size(), started)
+		started = time.Now()
+		i.batchCommitted(count, 0)
+		b.Reset()
+		return nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fail(ctx.Err())
+		case s, ok := <-songs:
+			if !ok {
+				if b.Size() > 0 {
+					if err = flush(); err != nil {
+						return fail(err)
+					}
+				}
+				console.Log("Finished indexing.")
+				if err = i.swap(next); err != nil {
+					return err
+				}
+				i.indexed()
+				return nil
+			}
+			if err = indexSong(b, count, s); err != nil {
+				return fail(err)
+			}
+			count += 1
+			if count%batchSize != 0 {
+				continue
+			}
+			if err = flush(); err != nil {
+				return fail(err)
+			}
+			if !i.waitWhilePaused(shutdown) {
+				return fail(fmt.Errorf("Aborting paused index batch at position %d", count))
+			}
+		}
+	}
+}
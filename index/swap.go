@@ -0,0 +1,135 @@
+package index
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ambientsound/pms/console"
+	"github.com/blevesearch/bleve"
+)
+
+// nextPath returns the path where a replacement index is built.
+func (i *Index) nextPath() string {
+	return i.indexPath + ".next"
+}
+
+// oldPath returns the path where the live index is moved while a replacement
+// index is swapped into place.
+func (i *Index) oldPath() string {
+	return i.indexPath + ".old"
+}
+
+// createNext creates an empty index at nextPath, using the same mapping as
+// the live index. Any leftovers from an earlier, interrupted build are
+// removed first.
+func (i *Index) createNext() (bleve.Index, error) {
+	if i.memOnly {
+		return createInMemory(i.mapping)
+	}
+
+	path := i.nextPath()
+
+	err := os.RemoveAll(path)
+	if err != nil {
+		return nil, fmt.Errorf("while removing stale index at %s: %s", path, err)
+	}
+
+	i.mutex.RLock()
+	mapping := i.bleveIndex.Mapping()
+	i.mutex.RUnlock()
+
+	index, err := newBleve(path, mapping, i.mapping.passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("while creating search index %s: %s", path, err)
+	}
+
+	// Rebuilding is how an index changes to the configured scoring model.
+	err = writeScoring(index, i.mapping.scoring)
+	if err != nil {
+		index.Close()
+		return nil, fmt.Errorf("while writing scoring model to search index %s: %s", path, err)
+	}
+
+	return index, nil
+}
+
+// discardNext closes and removes an index created by createNext.
+func (i *Index) discardNext(next bleve.Index) {
+	next.Close()
+	if i.memOnly {
+		return
+	}
+	if err := os.RemoveAll(i.nextPath()); err != nil {
+		console.Log("Unable to remove unfinished index at %s: %s", i.nextPath(), err)
+	}
+}
+
+// swap replaces the live index with an index created by createNext. The
+// write lock is held during the swap, so that no searches run against a
+// partially moved index. If the new index cannot be moved into place, the
+// old index is restored. ErrIndexClosed is returned if the index has been
+// closed in the meantime, or if the old index cannot be reopened.
+func (i *Index) swap(next bleve.Index) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	// The index was closed while indexing; do not bring it back to life.
+	if i.closed {
+		i.discardNext(next)
+		return ErrIndexClosed
+	}
+
+	// In-memory indexes have nothing to move around.
+	if i.memOnly {
+		i.bleveIndex.Close()
+		i.bleveIndex = next
+		console.Log("Swapped in new search index.")
+		return nil
+	}
+
+	err := next.Close()
+	if err != nil {
+		return fmt.Errorf("while closing new index: %s", err)
+	}
+
+	err = i.bleveIndex.Close()
+	if err != nil {
+		return fmt.Errorf("while closing live index: %s", err)
+	}
+
+	os.RemoveAll(i.oldPath())
+	err = os.Rename(i.indexPath, i.oldPath())
+	if err != nil {
+		return i.reopen(fmt.Errorf("while moving live index out of the way: %s", err))
+	}
+
+	err = os.Rename(i.nextPath(), i.indexPath)
+	if err == nil {
+		i.bleveIndex, err = open(i.indexPath, i.mapping.passphrase)
+	}
+	if err != nil {
+		console.Log("Unable to swap in new index, restoring old index: %s", err)
+		os.RemoveAll(i.indexPath)
+		os.Rename(i.oldPath(), i.indexPath)
+		return i.reopen(fmt.Errorf("while moving new index into place: %s", err))
+	}
+
+	os.RemoveAll(i.oldPath())
+	console.Log("Swapped in new search index.")
+
+	return nil
+}
+
+// reopen reopens the live index after a failed swap, and returns the error
+// that made the swap fail. If the live index cannot be reopened either, the
+// index is closed, and the returned error wraps ErrIndexClosed. The caller
+// must hold the write lock.
+func (i *Index) reopen(cause error) error {
+	index, err := open(i.indexPath, i.mapping.passphrase)
+	if err != nil {
+		i.closed = true
+		return fmt.Errorf("%w: %s; while reopening search index: %s", ErrIndexClosed, cause, err)
+	}
+	i.bleveIndex = index
+	return cause
+}
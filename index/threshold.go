@@ -0,0 +1,40 @@
+package index
+
+import (
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// scoredByRelevance returns false if the query, or any query it is composed
+// of, is of a type whose scores do not reflect relevance in the same way as
+// full-text queries do. Prefix, wildcard, regular expression and fuzzy
+// queries yield low, near-constant scores, and the score threshold would
+// discard all of their results.
+func scoredByRelevance(q query.Query) bool {
+	switch q := q.(type) {
+	case *query.PrefixQuery, *query.WildcardQuery, *query.RegexpQuery, *query.FuzzyQuery:
+		return false
+	case *query.MatchQuery:
+		return q.Fuzziness == 0
+	case *query.ConjunctionQuery:
+		return allScoredByRelevance(q.Conjuncts)
+	case *query.DisjunctionQuery:
+		return allScoredByRelevance(q.Disjuncts)
+	case *query.BooleanQuery:
+		return (q.Must == nil || scoredByRelevance(q.Must)) &&
+			(q.Should == nil || scoredByRelevance(q.Should))
+	case *query.QueryStringQuery:
+		parsed, err := q.Parse()
+		return err != nil || scoredByRelevance(parsed)
+	}
+	return true
+}
+
+// allScoredByRelevance returns true if scoredByRelevance is true for all queries.
+func allScoredByRelevance(queries []query.Query) bool {
+	for _, q := range queries {
+		if !scoredByRelevance(q) {
+			return false
+		}
+	}
+	return true
+}
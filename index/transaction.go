@@ -0,0 +1,83 @@
+package index
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ambientsound/pms/song"
+	"github.com/blevesearch/bleve"
+)
+
+// ErrTransactionDone is returned when using a transaction that has already
+// been committed or rolled back.
+var ErrTransactionDone = fmt.Errorf("Search index transaction has already been committed or rolled back")
+
+// Transaction accumulates changes to the index, which are either applied
+// together by Commit, or discarded by Rollback.
+type Transaction struct {
+	index *Index
+	batch *bleve.Batch
+	done  bool
+}
+
+// Begin starts a new transaction against the index.
+func (i *Index) Begin() *Transaction {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return &Transaction{
+		index: i,
+		batch: i.bleveIndex.NewBatch(),
+	}
+}
+
+// Index adds or replaces the song at the given position.
+func (t *Transaction) Index(pos int, s *song.Song) error {
+	if t.done {
+		return ErrTransactionDone
+	}
+	return indexSong(t.batch, pos, s)
+}
+
+// Delete removes the song at the given position.
+func (t *Transaction) Delete(pos int) error {
+	if t.done {
+		return ErrTransactionDone
+	}
+	t.batch.Delete(strconv.Itoa(pos))
+	return nil
+}
+
+// Len returns the number of changes in the transaction.
+func (t *Transaction) Len() int {
+	return t.batch.Size()
+}
+
+// Commit applies all changes in the transaction to the index, in a single
+// batch. Either all changes are applied, or none of them are. While the index
+// is being rebuilt, ErrIndexingInProgress is returned, and the transaction
+// can be committed again later.
+func (t *Transaction) Commit() error {
+	if t.done {
+		return ErrTransactionDone
+	}
+
+	t.index.mutex.RLock()
+	err := t.index.writable()
+	if err == nil {
+		t.done = true
+		err = t.index.bleveIndex.Batch(t.batch)
+	}
+	t.index.mutex.RUnlock()
+
+	if err == nil {
+		t.index.checkSize()
+	}
+
+	return err
+}
+
+// Rollback discards all changes in the transaction.
+func (t *Transaction) Rollback() {
+	t.done = true
+	t.batch.Reset()
+}
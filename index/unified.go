@@ -0,0 +1,75 @@
+package index
+
+import (
+	"strconv"
+
+	"github.com/blevesearch/bleve"
+)
+
+// Source describes where a search hit was found.
+type Source int
+
+const (
+	SourceLibrary Source = 1 << iota
+	SourceQueue
+)
+
+// UnifiedHit is a search result from SearchUnified.
+type UnifiedHit struct {
+	Pos    int
+	Score  float64
+	Source Source
+}
+
+// SearchUnified searches both the song library and the queue for a natural
+// language query. The queue is given as a set of library positions. At most
+// size hits are returned from each source, and hits found in both are
+// returned once, tagged with both sources. Library hits are ordered by
+// score, and followed by any hits found only in the queue.
+func (i *Index) SearchUnified(q string, queuePositions map[int]bool, size int) ([]UnifiedHit, error) {
+	q = normalizeQuery(q)
+
+	library, _, err := i.queryScored(i.searchRequest(q, size), i.ScoreThreshold(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]UnifiedHit, len(library))
+	hits := make(map[int]int, len(library))
+	for n, r := range library {
+		result[n] = UnifiedHit{Pos: r.Pos, Score: r.Score, Source: SourceLibrary}
+		hits[r.Pos] = n
+	}
+
+	// Search the queue separately, so that queue hits are not crowded out
+	// by library hits with a higher score.
+	if len(queuePositions) > 0 {
+		ids := make([]string, 0, len(queuePositions))
+		for pos := range queuePositions {
+			ids = append(ids, strconv.Itoa(pos))
+		}
+
+		// The position filter must not change the relevance scores, or
+		// queue hits would be compared against the score threshold
+		// differently than library hits.
+		request := i.searchRequest(q, size)
+		filter := &additiveQuery{Query: bleve.NewDocIDQuery(ids), scale: 0}
+		request.Query = bleve.NewConjunctionQuery(request.Query, filter)
+
+		queue, _, err := i.queryScored(request, i.ScoreThreshold(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		// Queue-only hits are kept in their own order after the library hits.
+		for _, r := range queue {
+			if n, ok := hits[r.Pos]; ok {
+				result[n].Source |= SourceQueue
+			} else {
+				result = append(result, UnifiedHit{Pos: r.Pos, Score: r.Score, Source: SourceQueue})
+			}
+		}
+	}
+
+	return result, nil
+}
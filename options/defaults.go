@@ -5,6 +5,9 @@ package options
 func (o *Options) AddDefaultOptions() {
 	o.Add(NewBoolOption("center"))
 	o.Add(NewStringOption("columns"))
+	o.Add(NewStringOption("searchanalyzer"))
+	o.Add(NewFloatOption("searchthreshold"))
+	o.Add(NewIntOption("searchresults"))
 	o.Add(NewStringOption("sort"))
 	o.Add(NewStringOption("topbar"))
 }
@@ -14,6 +17,9 @@ const Defaults string = `
 # Global options
 set nocenter
 set columns=artist,track,title,album,year,time
+set searchanalyzer=song
+set searchthreshold=0.5
+set searchresults=0
 set sort=file,track,disc,album,year,albumartistsort
 set topbar="|$shortname $version||;${tag|artist} - ${tag|title}||${tag|album}, ${tag|year};$volume $mode $elapsed ${state} $time;|[${list|index}/${list|total}] ${list|title}||;;"
 
@@ -32,8 +38,10 @@ style originalyear darkgreen
 style allTagsMissing red
 style currentSong black yellow
 style cursor black white
+style facetCount darkgray
 style header green bold
 style mostTagsMissing red
+style searchMatch white bold
 style selection white blue
 
 # Topbar styles
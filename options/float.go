@@ -0,0 +1,41 @@
+package options
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type FloatOption struct {
+	key   string
+	value float64
+}
+
+func NewFloatOption(key string) *FloatOption {
+	return &FloatOption{key: key}
+}
+
+func (o *FloatOption) Set(value string) error {
+	var err error
+	o.value, err = strconv.ParseFloat(value, 64)
+	return err
+}
+
+func (o *FloatOption) Key() string {
+	return o.key
+}
+
+func (o *FloatOption) FloatValue() float64 {
+	return o.value
+}
+
+func (o *FloatOption) Value() interface{} {
+	return o.value
+}
+
+func (o *FloatOption) String() string {
+	return fmt.Sprintf("%s=%s", o.key, o.StringValue())
+}
+
+func (o *FloatOption) StringValue() string {
+	return strconv.FormatFloat(o.value, 'g', -1, 64)
+}
@@ -78,3 +78,13 @@ func (o *Options) BoolValue(key string) bool {
 		panic(fmt.Errorf("Expected boolean option in BoolValue(), got %T", val))
 	}
 }
+
+func (o *Options) FloatValue(key string) float64 {
+	val := o.Value(key)
+	switch val := val.(type) {
+	case float64:
+		return val
+	default:
+		panic(fmt.Errorf("Expected float option in FloatValue(), got %T", val))
+	}
+}
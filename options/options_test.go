@@ -1,6 +1,7 @@
 package options_test
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/ambientsound/pms/options"
@@ -25,6 +26,21 @@ func TestStringOption(t *testing.T) {
 	}
 }
 
+func TestStringOptionValidator(t *testing.T) {
+	opt := options.NewStringOption("foo")
+	opt.SetValidator(func(value string) error {
+		if value != "bar" {
+			return fmt.Errorf("invalid")
+		}
+		return nil
+	})
+	require.Nil(t, opt.Set("bar"))
+	require.NotNil(t, opt.Set("baz"))
+	if opt.StringValue() != "bar" {
+		t.Fatalf("String option value was changed by an invalid value!")
+	}
+}
+
 func TestIntOption(t *testing.T) {
 	opt := options.NewIntOption("foo")
 	err := opt.Set("3984")
@@ -43,6 +59,27 @@ func TestIntOption(t *testing.T) {
 	}
 }
 
+func TestFloatOption(t *testing.T) {
+	opt := options.NewFloatOption("foo")
+	err := opt.Set("0.25")
+	require.Nil(t, err)
+	if opt.Key() != "foo" {
+		t.Fatalf("Float option key is incorrect!")
+	}
+	val := opt.Value()
+	switch val := val.(type) {
+	case float64:
+		if val != 0.25 {
+			t.Fatalf("Float option value is incorrect!")
+		}
+	default:
+		t.Fatalf("Float option value is of wrong type %T!", val)
+	}
+	if opt.String() != "foo=0.25" {
+		t.Fatalf("Float option string is incorrect!")
+	}
+}
+
 func TestBoolOption(t *testing.T) {
 	opt := options.NewBoolOption("foo")
 	err := opt.Set("true")
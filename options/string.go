@@ -3,15 +3,27 @@ package options
 import "fmt"
 
 type StringOption struct {
-	key   string
-	value string
+	key      string
+	value    string
+	validate func(string) error
 }
 
 func NewStringOption(key string) *StringOption {
 	return &StringOption{key: key}
 }
 
+// SetValidator sets a function which checks new values of the option. Set
+// returns the error from the function, and keeps the old value.
+func (o *StringOption) SetValidator(validate func(string) error) {
+	o.validate = validate
+}
+
 func (o *StringOption) Set(value string) error {
+	if o.validate != nil {
+		if err := o.validate(value); err != nil {
+			return err
+		}
+	}
 	o.value = value
 	return nil
 }
@@ -65,6 +65,15 @@ func (pms *PMS) handleEventOption(key string) {
 		pms.setupTopbar()
 	case "columns":
 		// list changed, FIXME
+	case "searchthreshold", "searchresults":
+		// Options set before MPD has connected are applied by SyncLibrary.
+		library := pms.database.Library()
+		if library == nil {
+			return
+		}
+		pms.setupSearch(library)
+	case "searchanalyzer":
+		pms.reopenIndex()
 	}
 }
 
@@ -45,6 +45,9 @@ type PMS struct {
 	libraryVersion int
 	indexVersion   int
 
+	// Analyzer setting of the open search index.
+	indexAnalyzer string
+
 	// EventList receives a signal when current songlist has been changed.
 	EventList chan int
 
@@ -194,7 +197,8 @@ func (pms *PMS) SyncLibrary() error {
 		}
 
 		library.SetVersion(version)
-		library.OpenIndex(index.Path(pms.Connection.Host, pms.Connection.Port))
+		pms.setupSearch(library)
+		pms.openIndex(library)
 
 		pms.database.SetLibrary(library)
 
@@ -204,7 +208,7 @@ func (pms *PMS) SyncLibrary() error {
 	}
 
 	library := pms.database.Library()
-	if !library.IndexSynced() {
+	if library.HasIndex() && !library.IndexSynced() {
 		console.Log("Search index is not synchronized with library, rebuilding index...")
 		library.ReIndex()
 	}
@@ -212,6 +216,46 @@ func (pms *PMS) SyncLibrary() error {
 	return nil
 }
 
+// openIndex opens the search index of the current MPD server, as configured
+// by the search options.
+func (pms *PMS) openIndex(library *songlist.Library) {
+	host, port := pms.Connection.Host, pms.Connection.Port
+	analyzer := pms.Options.StringValue("searchanalyzer")
+	err := library.OpenIndex(index.Path(host, port),
+		index.Server(host, port),
+		index.Analyzer(analyzer),
+	)
+	pms.indexAnalyzer = analyzer
+	if err == index.ErrIndexLocked {
+		pms.Error("Search is unavailable: another PMS instance is using the search index.")
+	} else if err != nil {
+		pms.Error("Error while opening search index: %s", err)
+	}
+}
+
+// reopenIndex reopens the search index of the current library after the
+// search options have changed, and rebuilds it if needed. The open index is
+// kept if the new options are invalid.
+func (pms *PMS) reopenIndex() {
+	library := pms.database.Library()
+	analyzer := pms.Options.StringValue("searchanalyzer")
+	if library == nil || !library.HasIndex() || pms.indexAnalyzer == analyzer {
+		return
+	}
+
+	if err := index.ValidAnalyzer(analyzer); err != nil {
+		pms.Error("Search index settings not changed: %s", err)
+		return
+	}
+
+	pms.openIndex(library)
+
+	if library.HasIndex() && !library.IndexSynced() {
+		console.Log("Search index settings have changed, rebuilding index...")
+		library.ReIndex()
+	}
+}
+
 func (pms *PMS) SyncQueue() error {
 	if err := pms.UpdatePlayerStatus(); err != nil {
 		return err
@@ -1,11 +1,13 @@
 package pms
 
 import (
+	"context"
 	"time"
 
 	"github.com/ambientsound/pms/api"
 	"github.com/ambientsound/pms/console"
 	"github.com/ambientsound/pms/db"
+	"github.com/ambientsound/pms/index"
 	"github.com/ambientsound/pms/input"
 	"github.com/ambientsound/pms/input/keys"
 	"github.com/ambientsound/pms/message"
@@ -13,6 +15,7 @@ import (
 	"github.com/ambientsound/pms/songlist"
 	"github.com/ambientsound/pms/style"
 	"github.com/ambientsound/pms/topbar"
+	"github.com/ambientsound/pms/utils"
 	"github.com/ambientsound/pms/widgets"
 )
 
@@ -35,6 +38,7 @@ func New() *PMS {
 
 	pms.Options = options.New()
 	pms.Options.AddDefaultOptions()
+	pms.setupOptionValidators()
 
 	pms.Sequencer = keys.NewSequencer()
 
@@ -88,3 +92,37 @@ func (pms *PMS) setupTopbar() {
 		pms.Error("Error in topbar configuration: %s", err)
 	}
 }
+
+// setupOptionValidators makes the set command reject invalid values of
+// options that are checked before they are applied.
+func (pms *PMS) setupOptionValidators() {
+	analyzer := pms.Options.Get("searchanalyzer").(*options.StringOption)
+	analyzer.SetValidator(index.ValidAnalyzer)
+}
+
+// setupSearch configures library searches according to the search options.
+func (pms *PMS) setupSearch(library *songlist.Library) {
+	library.SetSearchThreshold(pms.Options.FloatValue("searchthreshold"))
+	library.SetSearchResults(pms.Options.IntValue("searchresults"))
+	library.OnReIndexProgress(pms.showIndexProgress)
+}
+
+// showIndexProgress shows the progress of rebuilding the search index in the
+// statusbar.
+func (pms *PMS) showIndexProgress(progress index.IndexProgress) {
+	switch {
+	case !progress.Finished:
+		pms.Message("Indexing songs: %d/%d (%d%%), about %s remaining. Type :index abort to stop.",
+			progress.Done,
+			progress.Total,
+			100*progress.Done/utils.Max(1, progress.Total),
+			progress.ETA/time.Second*time.Second,
+		)
+	case progress.Err == context.Canceled:
+		pms.Message("Indexing aborted.")
+	case progress.Err != nil:
+		pms.Error("Error while indexing songs: %s", progress.Err)
+	default:
+		pms.Message("Search index is ready: %d songs indexed in %s.", progress.Total, progress.Elapsed/time.Second*time.Second)
+	}
+}
@@ -1,13 +1,14 @@
 package songlist
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/ambientsound/pms/console"
 	"github.com/ambientsound/pms/index"
-	"github.com/blevesearch/bleve"
+	"github.com/ambientsound/pms/song"
 )
 
 // Library is a Songlist which represents the MPD song library.
@@ -15,12 +16,16 @@ type Library struct {
 	BaseSonglist
 	index           *index.Index
 	version         int
-	shutdownReIndex chan int
+	cancelReIndex   context.CancelFunc
+	reIndexDone     chan struct{}
+	reIndexProgress func(index.IndexProgress)
+	searchThreshold float64
+	searchResults   int
 }
 
 func NewLibrary() (s *Library) {
 	s = &Library{
-		shutdownReIndex: make(chan int, 1),
+		searchThreshold: index.SEARCH_SCORE_THRESHOLD,
 	}
 	s.clear()
 	return
@@ -55,7 +60,7 @@ func (s *Library) RemoveIndices(indices []int) error {
 }
 
 // OpenIndex configures the library to use the Bleve search index at the specified path.
-func (s *Library) OpenIndex(path string) error {
+func (s *Library) OpenIndex(path string, options ...index.Option) error {
 	var err error
 
 	if s.HasIndex() {
@@ -65,9 +70,14 @@ func (s *Library) OpenIndex(path string) error {
 		s.index = nil
 	}
 
-	s.index, err = index.New(path)
+	s.index, err = index.New(path, options...)
+	if err != nil {
+		return err
+	}
+
+	s.index.SetScoreThreshold(s.searchThreshold)
 
-	return err
+	return nil
 }
 
 // HasIndex returns true if the library has a search index.
@@ -98,102 +108,259 @@ func (s *Library) Version() int {
 	return s.version
 }
 
+// SearchHistory returns recent search queries, oldest first. The history is
+// empty if the search index is not open.
+func (s *Library) SearchHistory() []string {
+	if !s.HasIndex() {
+		return []string{}
+	}
+	return s.index.History()
+}
+
+// AddSearchHistory appends a search query to the search history, which is
+// kept alongside the search index.
+func (s *Library) AddSearchHistory(q string) error {
+	if !s.HasIndex() {
+		return fmt.Errorf("Search index is not open.")
+	}
+	return s.index.AddHistory(q)
+}
+
+// IndexStats returns diagnostic information about the search index.
+func (s *Library) IndexStats() (index.IndexStats, error) {
+	if !s.HasIndex() {
+		return index.IndexStats{}, fmt.Errorf("Search index is not open.")
+	}
+	return s.index.Stats(), nil
+}
+
+// PurgeIndex deletes the search index from disk, replacing it with an empty
+// index, and starts rebuilding it. Any reindexing job in progress is aborted
+// first.
+func (s *Library) PurgeIndex() error {
+	if !s.HasIndex() {
+		return fmt.Errorf("Search index is not open.")
+	}
+
+	s.AbortReIndex()
+	if s.reIndexDone != nil {
+		<-s.reIndexDone
+	}
+
+	if err := s.index.Purge(); err != nil {
+		return err
+	}
+
+	s.ReIndex()
+
+	return nil
+}
+
+// SetSearchThreshold sets the minimum score of search results. See
+// index.Index.SetScoreThreshold.
+func (s *Library) SetSearchThreshold(threshold float64) {
+	s.searchThreshold = threshold
+	if s.HasIndex() {
+		s.index.SetScoreThreshold(threshold)
+	}
+}
+
+// SetSearchResults sets the maximum number of songs returned by Search. If
+// the limit is zero or less, all matching songs are returned.
+func (s *Library) SetSearchResults(size int) {
+	s.searchResults = size
+}
+
+// searchSize returns the number of results requested by Search.
+func (s *Library) searchSize() int {
+	if s.searchResults > 0 {
+		return s.searchResults
+	}
+	return s.Len()
+}
+
 // ReIndex starts an asynchronous reindexing job. In case this function is
 // called again before reindexing is done, ReIndex will abort the old
-// reindexing job.
+// reindexing job, and start the new one once the old one has stopped.
 func (s *Library) ReIndex() {
-	s.shutdownReIndex <- 0
-	s.shutdownReIndex = make(chan int, 1)
+	s.AbortReIndex()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancelReIndex = cancel
+	previous := s.reIndexDone
+	done := make(chan struct{})
+	s.reIndexDone = done
+	progress := s.reIndexProgress
 	go func() {
+		defer close(done)
+		defer cancel()
+		if previous != nil {
+			<-previous
+		}
 		timer := time.Now()
-		err := s.index.IndexFull(s.Songs(), s.shutdownReIndex)
+		var last index.IndexProgress
+		for last = range s.index.IndexFullAsync(ctx, s.Songs()) {
+			if progress != nil {
+				progress(last)
+			}
+		}
 		console.Log("Song library index complete, took %s", time.Since(timer).String())
 
-		if err != nil {
-			console.Log("Error occurred during library reindex: %s", err)
+		if last.Err != nil {
+			console.Log("Error occurred during library reindex: %s", last.Err)
 			return
 		}
 		s.index.SetVersion(s.Version())
 	}()
 }
 
+// AbortReIndex aborts the reindexing job started by ReIndex, if it is still
+// running. The old index is kept. True is returned if a job was aborted.
+func (s *Library) AbortReIndex() bool {
+	if s.reIndexDone == nil {
+		return false
+	}
+	select {
+	case <-s.reIndexDone:
+		return false
+	default:
+		s.cancelReIndex()
+		return true
+	}
+}
+
+// OnReIndexProgress registers a function which receives the progress events
+// of reindexing jobs started by ReIndex, from another goroutine. See
+// index.Index.IndexFullAsync.
+func (s *Library) OnReIndexProgress(progress func(index.IndexProgress)) {
+	s.reIndexProgress = progress
+}
+
+// searchFacetTags lists the tags whose values are counted by Search.
+var searchFacetTags = []string{"artist", "album", "genre"}
+
 // Search does a search in the Bleve index for a specific natural language
-// query string, and returns a new Songlist with the search results.
+// query string, and returns a new Songlist with the search results. The
+// matching parts of the song tags are highlighted, and the distinct artists,
+// albums and genres among the results are counted; see SearchResult.
 func (s *Library) Search(q string) (Songlist, error) {
 	if !s.HasIndex() {
 		return nil, fmt.Errorf("Search index is not open.")
 	}
 
-	query := bleve.NewQueryStringQuery(q)
-	request := bleve.NewSearchRequest(query)
-	request.Size = s.Len()
+	facets := make([]string, 0, len(searchFacetTags))
+	for _, tag := range searchFacetTags {
+		if s.index.Indexed(tag) {
+			facets = append(facets, tag)
+		}
+	}
 
-	ids, _, err := s.index.Query(request)
+	r, err := s.index.SearchWith(q, index.SearchOptions{
+		Size:      s.searchSize(),
+		Highlight: true,
+		Facets:    facets,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	list := New()
+	list := NewSearchResult()
 	list.SetName(q)
+	list.SetFacets(r.Facets)
 
-	for _, id := range ids {
+	for n, id := range r.Positions {
 		song := s.Song(id)
 		if song == nil {
 			return nil, fmt.Errorf("Search index is corrupt.")
 		}
 		list.Add(song)
+		for tag, spans := range r.Spans[n] {
+			list.SetHighlights(song, tag, spans)
+		}
 	}
 
 	return list, nil
 }
 
-// Isolate takes a songlist and a set of tag keys, and matches the tag values
-// of the songlist against the search index.
-func (s *Library) Isolate(songs Songlist, tags []string) (Songlist, error) {
+// DrillDown returns a new Songlist with the songs of a list where the given
+// tag is exactly equal to value, ignoring case and diacritics, in the order
+// of the list. See index.Index.FilterExact.
+func (s *Library) DrillDown(songs Songlist, tag, value string) (Songlist, error) {
 	if !s.HasIndex() {
 		return nil, fmt.Errorf("Search index is not open.")
 	}
 
-	terms := make(map[string]struct{})
-	query := bleve.NewBooleanQuery()
+	r, err := s.index.FilterExact(tag, value)
+	if err != nil {
+		return nil, err
+	}
 
-	// Create a cartesian join for song values and tag list.
+	matches := make(map[*song.Song]bool, len(r))
+	for _, id := range r {
+		matches[s.Song(id)] = true
+	}
+
+	list := New()
+	list.SetName(fmt.Sprintf("%s: %s", songs.Name(), value))
 	for _, song := range songs.Songs() {
-		subQuery := bleve.NewConjunctionQuery()
+		if matches[song] {
+			list.Add(song)
+		}
+	}
 
-		for _, tag := range tags {
+	return list, nil
+}
 
-			// Ignore empty values
-			tagValue := song.StringTags[tag]
-			if len(tagValue) == 0 {
-				continue
-			}
+// Similar returns a new Songlist with songs resembling the given song, by
+// artist, genre and album, ordered by decreasing similarity.
+func (s *Library) Similar(sng *song.Song) (Songlist, error) {
+	if !s.HasIndex() {
+		return nil, fmt.Errorf("Search index is not open.")
+	}
+
+	r, err := s.index.Similar(sng, s.searchSize())
+	if err != nil {
+		return nil, err
+	}
 
-			// Name generation
-			terms[tagValue] = struct{}{}
+	name := sng.StringTags["title"]
+	if len(name) == 0 {
+		name = sng.StringTags["file"]
+	}
+	list := s.Indices(r)
+	list.SetName(fmt.Sprintf("Similar to %s", name))
 
-			field := strings.Title(tag)
-			query := bleve.NewMatchPhraseQuery(tagValue)
-			query.SetField(field)
-			subQuery.AddQuery(query)
-		}
-		query.AddShould(subQuery)
+	return list, nil
+}
+
+// Isolate takes a songlist and a set of tag keys, and matches the tag values
+// of the songlist against the search index.
+func (s *Library) Isolate(songs Songlist, tags []string) (Songlist, error) {
+	if !s.HasIndex() {
+		return nil, fmt.Errorf("Search index is not open.")
+	}
+
+	r, err := s.index.Isolate(songs.Songs(), tags)
+	if err != nil {
+		return nil, err
 	}
 
 	// Construct a fitting name for this track list
+	terms := make(map[string]struct{})
+	for _, song := range songs.Songs() {
+		for _, tag := range tags {
+			if tagValue := song.StringTags[tag]; len(tagValue) > 0 {
+				terms[tagValue] = struct{}{}
+			}
+		}
+	}
 	names := make([]string, 0)
 	for k := range terms {
 		names = append(names, k)
 	}
 	name := strings.Join(names, ", ")
 
-	// Make the search
-	request := bleve.NewSearchRequest(query)
-	request.Size = s.Len()
-	r, _, err := s.index.Query(request)
 	list := s.Indices(r)
-
 	list.SetName(name)
 
-	return list, err
+	return list, nil
 }
@@ -0,0 +1,65 @@
+package songlist
+
+import (
+	"github.com/ambientsound/pms/index"
+	"github.com/ambientsound/pms/song"
+)
+
+// Highlighter is implemented by songlists which mark parts of the song tags,
+// such as the terms matched by a search.
+type Highlighter interface {
+	// Highlights returns the marked ranges of bytes in a song tag.
+	Highlights(s *song.Song, tag string) []index.Span
+}
+
+// SearchResult is a Songlist holding the results of a search, along with
+// the parts of each song's tags that matched the search, and the number of
+// songs having each value of some tags.
+type SearchResult struct {
+	BaseSonglist
+	highlights map[*song.Song]map[string][]index.Span
+	facets     []index.FacetResult
+}
+
+func NewSearchResult() (s *SearchResult) {
+	s = &SearchResult{
+		highlights: make(map[*song.Song]map[string][]index.Span),
+	}
+	s.clear()
+	return
+}
+
+// SetHighlights marks the given ranges of a song tag.
+func (s *SearchResult) SetHighlights(sng *song.Song, tag string, spans []index.Span) {
+	if s.highlights[sng] == nil {
+		s.highlights[sng] = make(map[string][]index.Span)
+	}
+	s.highlights[sng][tag] = spans
+}
+
+// Highlights implements Highlighter.
+func (s *SearchResult) Highlights(sng *song.Song, tag string) []index.Span {
+	return s.highlights[sng][tag]
+}
+
+// SetFacets stores the distinct tag values among the search results.
+func (s *SearchResult) SetFacets(facets []index.FacetResult) {
+	s.facets = facets
+}
+
+// Facets returns the distinct tag values among the search results, along
+// with the number of songs having each value; see index.Index.Facets.
+func (s *SearchResult) Facets() []index.FacetResult {
+	return s.facets
+}
+
+// Facet returns the distinct values of a tag among the search results. Nil
+// is returned if the values of the tag were not counted.
+func (s *SearchResult) Facet(tag string) []index.FacetTerm {
+	for _, facet := range s.facets {
+		if facet.Field == tag {
+			return facet.Terms
+		}
+	}
+	return nil
+}
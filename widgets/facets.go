@@ -0,0 +1,101 @@
+package widgets
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ambientsound/pms/index"
+	"github.com/ambientsound/pms/style"
+	"github.com/gdamore/tcell"
+	"github.com/gdamore/tcell/views"
+)
+
+// facetsWidth is the width of the facets widget, when it is visible.
+const facetsWidth = 32
+
+// FacetsWidget is a tcell widget which draws the distinct tag values among
+// the results of a search, along with the number of songs having each value.
+// The values are numbered, so that they can be chosen with the drilldown
+// command. The widget is hidden when there are no facets to draw.
+type FacetsWidget struct {
+	facets []index.FacetResult
+	view   views.View
+
+	style.Styled
+	views.WidgetWatchers
+}
+
+func NewFacetsWidget() *FacetsWidget {
+	return &FacetsWidget{}
+}
+
+// SetFacets sets the facets to draw. Nil hides the widget.
+func (w *FacetsWidget) SetFacets(facets []index.FacetResult) {
+	w.facets = facets
+}
+
+// Visible returns true if the widget has any facets to draw.
+func (w *FacetsWidget) Visible() bool {
+	return len(w.facets) > 0
+}
+
+func (w *FacetsWidget) Draw() {
+	if w.view == nil || !w.Visible() {
+		return
+	}
+
+	width, height := w.view.Size()
+	y := 0
+
+	for _, facet := range w.facets {
+		if y >= height {
+			return
+		}
+		title := fmt.Sprintf("%s (%d)", strings.Title(facet.Field), len(facet.Terms))
+		w.drawText(1, y, width-1, []rune(title), w.Style("header"))
+		y++
+
+		for n, term := range facet.Terms {
+			if y >= height {
+				return
+			}
+			number := []rune(fmt.Sprintf("%3d ", n+1))
+			count := []rune(fmt.Sprintf(" %d", term.Count))
+			x := w.drawText(0, y, width, number, w.Style("facetCount"))
+			w.drawText(x, y, width-x-len(count), []rune(term.Value), w.Style(facet.Field))
+			w.drawText(width-len(count), y, len(count), count, w.Style("facetCount"))
+			y++
+		}
+
+		// Leave a blank line between facets.
+		y++
+	}
+}
+
+// drawText draws at most width runes at the given position, and returns the
+// position following the last rune drawn.
+func (w *FacetsWidget) drawText(x, y, width int, runes []rune, style tcell.Style) int {
+	for n := 0; n < len(runes) && n < width; n++ {
+		w.view.SetContent(x, y, runes[n], nil, style)
+		x++
+	}
+	return x
+}
+
+func (w *FacetsWidget) SetView(v views.View) {
+	w.view = v
+}
+
+func (w *FacetsWidget) Size() (int, int) {
+	if !w.Visible() {
+		return 0, 0
+	}
+	return facetsWidth, 0
+}
+
+func (w *FacetsWidget) Resize() {
+}
+
+func (w *FacetsWidget) HandleEvent(ev tcell.Event) bool {
+	return false
+}
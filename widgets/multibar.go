@@ -107,6 +107,16 @@ func (m *MultibarWidget) History() *history {
 	return &m.history[m.inputMode]
 }
 
+// loadSearchHistory replaces the search mode history with the search history
+// of the song library, which persists across restarts.
+func (m *MultibarWidget) loadSearchHistory() {
+	library := m.api.Library()
+	if library == nil || !library.HasIndex() {
+		return
+	}
+	m.history[constants.MultibarModeSearch].items = library.SearchHistory()
+}
+
 func (m *MultibarWidget) SetMessage(msg message.Message) {
 	switch {
 	case msg.Type == message.SequenceText:
@@ -133,6 +143,9 @@ func (m *MultibarWidget) SetMode(mode int) error {
 	console.Log("Switching input mode from %d to %d", m.inputMode, mode)
 	m.inputMode = mode
 	m.setRunes(make([]rune, 0))
+	if mode == constants.MultibarModeSearch {
+		m.loadSearchHistory()
+	}
 	m.History().Reset("")
 	PostEventInputChanged(m)
 	return nil
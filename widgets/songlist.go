@@ -7,6 +7,7 @@ import (
 
 	"github.com/ambientsound/pms/api"
 	"github.com/ambientsound/pms/console"
+	"github.com/ambientsound/pms/index"
 	"github.com/ambientsound/pms/song"
 	"github.com/ambientsound/pms/songlist"
 	"github.com/ambientsound/pms/style"
@@ -37,10 +38,20 @@ func NewSonglistWidget(a api.API) (w *SonglistWidget) {
 }
 
 func (w *SonglistWidget) drawNext(x, y, strmin, strmax int, runes []rune, style tcell.Style) int {
+	return w.drawNextHighlighted(x, y, strmin, strmax, runes, style, nil, style)
+}
+
+// drawNextHighlighted works like drawNext, but draws the runes marked in
+// the highlight mask with the highlight style.
+func (w *SonglistWidget) drawNextHighlighted(x, y, strmin, strmax int, runes []rune, style tcell.Style, mask []bool, highlight tcell.Style) int {
 	strmin = utils.Min(len(runes), strmin)
 	n := 0
 	for n < strmin {
-		w.viewport.SetContent(x, y, runes[n], nil, style)
+		if n < len(mask) && mask[n] {
+			w.viewport.SetContent(x, y, runes[n], nil, highlight)
+		} else {
+			w.viewport.SetContent(x, y, runes[n], nil, style)
+		}
 		n++
 		x++
 	}
@@ -147,8 +158,16 @@ func (w *SonglistWidget) Draw() {
 			// Convert tag to runes
 			key := w.columns[col].Tag()
 			runes := s.Tags[key]
+			highlight := style.Bold(true)
 			if !lineStyled {
 				style = w.Style(key)
+				highlight = w.Style("searchMatch")
+			}
+
+			// Mark the parts of the tag matched by a search.
+			var mask []bool
+			if highlighter, ok := list.(songlist.Highlighter); ok {
+				mask = highlightMask(s.StringTags[key], highlighter.Highlights(s, key))
 			}
 
 			if col+1 == len(w.columns) {
@@ -158,7 +177,7 @@ func (w *SonglistWidget) Draw() {
 			strmax := w.columns[col].Width()
 			strmin := strmax - rightPadding
 
-			x = w.drawNext(x, y, strmin, strmax, runes, style)
+			x = w.drawNextHighlighted(x, y, strmin, strmax, runes, style, mask, highlight)
 		}
 	}
 
@@ -166,6 +185,28 @@ func (w *SonglistWidget) Draw() {
 	PostEventScroll(w)
 }
 
+// highlightMask returns a mask marking the runes of a tag which fall within
+// the given byte ranges. Nil is returned if there are no ranges.
+func highlightMask(value string, spans []index.Span) []bool {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	mask := make([]bool, 0, len(value))
+	for offset := range value {
+		marked := false
+		for _, span := range spans {
+			if offset >= span.Start && offset < span.End {
+				marked = true
+				break
+			}
+		}
+		mask = append(mask, marked)
+	}
+
+	return mask
+}
+
 func (w *SonglistWidget) GetVisibleBoundaries() (ymin, ymax int) {
 	_, ymin, _, ymax = w.viewport.GetVisible()
 	return
@@ -25,6 +25,7 @@ type UI struct {
 	Columnheaders *ColumnheadersWidget
 	Multibar      *MultibarWidget
 	Songlist      *SonglistWidget
+	Facets        *FacetsWidget
 
 	// Input events
 	EventInputCommand chan string
@@ -62,6 +63,7 @@ func NewUI(a api.API) *UI {
 	ui.Columnheaders = NewColumnheadersWidget()
 	ui.Multibar = NewMultibarWidget(ui.api, ui.EventKeyInput)
 	ui.Songlist = NewSonglistWidget(ui.api)
+	ui.Facets = NewFacetsWidget()
 
 	ui.Multibar.Watch(ui)
 	ui.Songlist.Watch(ui)
@@ -71,6 +73,7 @@ func NewUI(a api.API) *UI {
 	ui.Topbar.SetStylesheet(ui.api.Styles())
 	ui.Columnheaders.SetStylesheet(ui.api.Styles())
 	ui.Songlist.SetStylesheet(ui.api.Styles())
+	ui.Facets.SetStylesheet(ui.api.Styles())
 	ui.Multibar.SetStylesheet(ui.api.Styles())
 
 	ui.CreateLayout()
@@ -81,10 +84,17 @@ func NewUI(a api.API) *UI {
 }
 
 func (ui *UI) CreateLayout() {
+	// The facets of a search are drawn to the right of the tracklist.
+	tracklist := views.NewBoxLayout(views.Vertical)
+	tracklist.AddWidget(ui.Columnheaders, 0)
+	tracklist.AddWidget(ui.Songlist, 1)
+	content := views.NewBoxLayout(views.Horizontal)
+	content.AddWidget(tracklist, 1)
+	content.AddWidget(ui.Facets, 0)
+
 	ui.Layout = views.NewBoxLayout(views.Vertical)
 	ui.Layout.AddWidget(ui.Topbar, 1)
-	ui.Layout.AddWidget(ui.Columnheaders, 0)
-	ui.Layout.AddWidget(ui.Songlist, 2)
+	ui.Layout.AddWidget(content, 2)
 	ui.Layout.AddWidget(ui.Multibar, 0)
 	ui.Layout.SetView(ui.view)
 }
@@ -153,6 +163,7 @@ func (ui *UI) HandleEvent(ev tcell.Event) bool {
 		cols := ui.api.Songlist().Columns(tags)
 		ui.Songlist.SetColumns(tags)
 		ui.Columnheaders.SetColumns(cols)
+		ui.showFacets()
 		return true
 
 	case *EventInputChanged:
@@ -174,9 +185,11 @@ func (ui *UI) HandleEvent(ev tcell.Event) bool {
 		case constants.MultibarModeInput:
 			ui.EventInputCommand <- term
 		case constants.MultibarModeSearch:
+			ui.addSearchHistory(term)
 			if ui.searchResult != nil {
 				if ui.searchResult.Len() > 0 {
 					ui.api.Db().Panel().Add(ui.searchResult)
+					ui.showSearchFacets()
 				} else {
 					ui.searchResult = nil
 				}
@@ -218,6 +231,54 @@ func (ui *UI) runIndexSearch(term string) error {
 	return err
 }
 
+// addSearchHistory remembers a finished search, so that it can be recalled
+// after restarting PMS. Aborted searches are empty, and are not remembered.
+func (ui *UI) addSearchHistory(term string) {
+	library := ui.api.Library()
+	if library == nil || !library.HasIndex() {
+		return
+	}
+
+	if err := library.AddSearchHistory(term); err != nil {
+		console.Log("Error while saving search history: %s", err)
+	}
+}
+
+// showSearchFacets shows the number of distinct tag values among the results
+// of a search in the statusbar, e.g. "4 artists, 23 albums matched".
+func (ui *UI) showSearchFacets() {
+	result, ok := ui.searchResult.(*songlist.SearchResult)
+	if !ok || len(result.Facets()) == 0 {
+		return
+	}
+
+	facets := result.Facets()
+	counts := make([]string, len(facets))
+	for n, facet := range facets {
+		counts[n] = fmt.Sprintf("%d %s", len(facet.Terms), facet.Field)
+		if len(facet.Terms) != 1 {
+			counts[n] += "s"
+		}
+	}
+
+	ui.api.Message("%s matched", strings.Join(counts, ", "))
+}
+
+// showFacets shows the facets of the current songlist next to the tracklist,
+// if it holds search results. The layout is recreated when the facets are
+// shown or hidden.
+func (ui *UI) showFacets() {
+	visible := ui.Facets.Visible()
+	if result, ok := ui.api.Songlist().(*songlist.SearchResult); ok {
+		ui.Facets.SetFacets(result.Facets())
+	} else {
+		ui.Facets.SetFacets(nil)
+	}
+	if ui.Facets.Visible() != visible {
+		ui.Resize()
+	}
+}
+
 func (ui *UI) showSearchResult() {
 	panel := ui.api.Db().Panel()
 	if ui.searchResult != nil {